@@ -0,0 +1,254 @@
+// Package say is a plugin built in the bot: "!say <words>" plays the bundled
+// clip of every known word, one after the other.
+package say
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jonas747/dca"
+	"github.com/spf13/viper"
+	"gitlab.com/Shywim/airhornbot/pluginapi"
+)
+
+const (
+	command = "say"
+
+	// Default limits of a sentence, the "max_words" and "max_seconds"
+	// settings change them
+	defaultMaxWords   = 20
+	defaultMaxSeconds = 30
+
+	// Silence between two words, in frames
+	gapFrames = 5
+
+	framesPerSecond = 50
+)
+
+// An opus frame of silence
+var silence = []byte{0xF8, 0xFF, 0xFE}
+
+type sayPlugin struct {
+	wordsPath string
+	clips     map[string][][]byte
+	maxWords  int
+	maxFrames int
+}
+
+// New returns the say plugin, its words are read from the TOML file at
+// wordsPath when it is initialized. The "words" setting replaces this file.
+func New(wordsPath string) pluginapi.Plugin {
+	return &sayPlugin{wordsPath: wordsPath}
+}
+
+func (p *sayPlugin) Metadata() pluginapi.Metadata {
+	return pluginapi.Metadata{
+		Name:        "say",
+		Version:     "1.0.0",
+		Description: "Says words with the bundled sounds",
+		Author:      "airhornbot",
+	}
+}
+
+func (p *sayPlugin) Init(cfg pluginapi.Config) error {
+	if path, ok := cfg.Settings["words"].(string); ok && path != "" {
+		p.wordsPath = path
+	}
+	p.maxWords = intSetting(cfg.Settings, "max_words", defaultMaxWords)
+	p.maxFrames = intSetting(cfg.Settings, "max_seconds", defaultMaxSeconds) * framesPerSecond
+
+	words, err := readWords(p.wordsPath)
+	if err != nil {
+		return err
+	}
+
+	// clips are short, they are kept in memory and shared by the words
+	// using the same file
+	byFile := make(map[string][][]byte)
+	p.clips = make(map[string][][]byte)
+	for word, file := range words {
+		frames, ok := byFile[file]
+		if !ok {
+			frames, err = readClip(file)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"word":  word,
+					"file":  file,
+					"error": err,
+				}).Warn("Couldn't read clip, skipping word")
+				continue
+			}
+			byFile[file] = frames
+		}
+		p.clips[word] = frames
+	}
+
+	log.WithFields(log.Fields{
+		"words": len(p.clips),
+	}).Info("Loaded say words")
+	return nil
+}
+
+func (p *sayPlugin) Commands() []pluginapi.Command {
+	return []pluginapi.Command{{
+		Name:        command,
+		Description: fmt.Sprintf("Says up to %d words (%s)", p.maxWords, strings.Join(p.words(), ", ")),
+	}}
+}
+
+func (p *sayPlugin) Handles(cmd string) bool {
+	return cmd == command
+}
+
+func (p *sayPlugin) Sound(ctx context.Context, req pluginapi.Request) (pluginapi.Stream, error) {
+	if req.Command != command {
+		return nil, pluginapi.ErrUnknownCommand
+	}
+
+	var clips [][][]byte
+	for _, arg := range req.Args {
+		if len(clips) == p.maxWords {
+			break
+		}
+		if clip, ok := p.clips[normalize(arg)]; ok {
+			clips = append(clips, clip)
+		}
+	}
+	if len(clips) == 0 {
+		return nil, pluginapi.ErrNoSound
+	}
+
+	return &sentence{ctx: ctx, clips: clips, left: p.maxFrames}, nil
+}
+
+// words returns the known words, sorted
+func (p *sayPlugin) words() []string {
+	words := make([]string, 0, len(p.clips))
+	for w := range p.clips {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	return words
+}
+
+// sentence plays clips separated by a short silence
+type sentence struct {
+	ctx   context.Context
+	clips [][][]byte
+	frame int
+	gap   int
+	left  int
+}
+
+func (s *sentence) NextFrame() ([]byte, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.clips) == 0 || s.left <= 0 {
+		return nil, io.EOF
+	}
+	s.left--
+
+	if s.gap > 0 {
+		s.gap--
+		return silence, nil
+	}
+
+	clip := s.clips[0]
+	frame := clip[s.frame]
+	s.frame++
+	if s.frame == len(clip) {
+		s.clips = s.clips[1:]
+		s.frame = 0
+		s.gap = gapFrames
+	}
+	return frame, nil
+}
+
+func (s *sentence) Close() error {
+	s.clips = nil
+	return nil
+}
+
+// readWords reads the words file, clip files are resolved relative to it
+func readWords(path string) (map[string]string, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	words := make(map[string]string)
+	for word, file := range v.GetStringMapString("words") {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		words[normalize(word)] = file
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("%s: no word found", path)
+	}
+	return words, nil
+}
+
+// readClip reads every opus frame of a dca file
+func readClip(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var frames [][]byte
+	decoder := dca.NewDecoder(file)
+	for {
+		frame, err := decoder.OpusFrame()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%s: empty clip", path)
+	}
+	return frames, nil
+}
+
+// normalize lowercases a word and removes its punctuation
+func normalize(word string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, word)
+}
+
+// intSetting returns a positive integer setting, or def if it is not set
+func intSetting(settings map[string]interface{}, key string, def int) int {
+	var n int
+	switch v := settings[key].(type) {
+	case int:
+		n = v
+	case int64:
+		n = int(v)
+	case float64:
+		n = int(v)
+	}
+
+	if n <= 0 {
+		return def
+	}
+	return n
+}
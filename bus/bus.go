@@ -0,0 +1,55 @@
+// Package bus carries events and queries between the bot and the web app,
+// over redis when they run as separate processes or in memory when they run
+// in the same one.
+package bus
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Errors of a query
+var (
+	ErrNoHandler = errors.New("bus: nobody answers this query")
+	ErrTimeout   = errors.New("bus: no answer in time")
+	ErrClosed    = errors.New("bus: closed")
+)
+
+// Message is an event or a query sent on the bus
+type Message interface {
+	// Topic the message is sent on
+	Topic() string
+}
+
+// Bus sends messages between the bot and the web app. Messages are encoded in
+// JSON, whichever the bus.
+type Bus interface {
+	// Publish sends an event to the subscribers of its topic
+	Publish(m Message) error
+
+	// Subscribe calls fn with every event of a topic
+	Subscribe(topic string, fn func(payload []byte)) error
+
+	// Request sends a query and decodes the first answer in reply, it
+	// returns ErrNoHandler if every handler left the query and ErrTimeout if
+	// none answered in time
+	Request(q Message, reply interface{}, timeout time.Duration) error
+
+	// Handle answers the queries of a topic with fn, fn returns nil to leave a
+	// query to another handler, e.g. when it is about a guild of another
+	// shard
+	Handle(topic string, fn func(payload []byte) interface{}) error
+
+	// Close stops the delivery of messages
+	Close() error
+}
+
+// reencode copies an answer in reply the same way whichever the bus
+func reencode(answer, reply interface{}) error {
+	b, err := json.Marshal(answer)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, reply)
+}
@@ -0,0 +1,110 @@
+package bus
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Local is a bus within a process
+type Local struct {
+	mu       sync.RWMutex
+	closed   bool
+	subs     map[string][]func([]byte)
+	handlers map[string][]func([]byte) interface{}
+}
+
+// NewLocal returns a bus within the process
+func NewLocal() *Local {
+	return &Local{
+		subs:     make(map[string][]func([]byte)),
+		handlers: make(map[string][]func([]byte) interface{}),
+	}
+}
+
+// Publish calls the subscribers of the topic of m, each in its own goroutine
+func (l *Local) Publish(m Message) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return ErrClosed
+	}
+	for _, fn := range l.subs[m.Topic()] {
+		go fn(payload)
+	}
+	return nil
+}
+
+// Subscribe calls fn with every event of a topic
+func (l *Local) Subscribe(topic string, fn func(payload []byte)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	l.subs[topic] = append(l.subs[topic], fn)
+	return nil
+}
+
+// Request asks every handler of the topic of q and decodes the first answer
+// in reply, it returns ErrNoHandler if they all left the query
+func (l *Local) Request(q Message, reply interface{}, timeout time.Duration) error {
+	payload, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	handlers := l.handlers[q.Topic()]
+	closed := l.closed
+	l.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	} else if len(handlers) == 0 {
+		return ErrNoHandler
+	}
+
+	answers := make(chan interface{}, len(handlers))
+	for _, fn := range handlers {
+		go func(fn func([]byte) interface{}) {
+			answers <- fn(payload)
+		}(fn)
+	}
+
+	deadline := time.After(timeout)
+	for range handlers {
+		select {
+		case answer := <-answers:
+			if answer != nil {
+				return reencode(answer, reply)
+			}
+		case <-deadline:
+			return ErrTimeout
+		}
+	}
+	return ErrNoHandler
+}
+
+// Handle answers the queries of a topic with fn
+func (l *Local) Handle(topic string, fn func(payload []byte) interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	l.handlers[topic] = append(l.handlers[topic], fn)
+	return nil
+}
+
+// Close stops the delivery of messages
+func (l *Local) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	return nil
+}
@@ -0,0 +1,157 @@
+package bus
+
+import (
+	"encoding/json"
+)
+
+// Topics of the events
+const (
+	TopicSoundChanged    = "sound.changed"
+	TopicSettingsChanged = "settings.changed"
+)
+
+// Topics of the queries
+const (
+	TopicNowPlaying  = "query.now_playing"
+	TopicVoiceStatus = "query.voice_status"
+	TopicPlaySound   = "query.play_sound"
+)
+
+// SoundChanged is sent when sounds of a guild are added, edited or deleted
+type SoundChanged struct {
+	GuildID string `json:"guildId"`
+	// Sound which changed, empty when several did
+	SoundID string `json:"soundId,omitempty"`
+}
+
+// Topic of the event
+func (*SoundChanged) Topic() string { return TopicSoundChanged }
+
+// SettingsChanged is sent when the settings, default sounds, plugins,
+// schedules or triggers of a guild change
+type SettingsChanged struct {
+	GuildID string `json:"guildId"`
+}
+
+// Topic of the event
+func (*SettingsChanged) Topic() string { return TopicSettingsChanged }
+
+// OnSoundChanged calls fn with every SoundChanged event
+func OnSoundChanged(b Bus, fn func(*SoundChanged)) error {
+	return b.Subscribe(TopicSoundChanged, func(payload []byte) {
+		m := &SoundChanged{}
+		if json.Unmarshal(payload, m) == nil {
+			fn(m)
+		}
+	})
+}
+
+// OnSettingsChanged calls fn with every SettingsChanged event
+func OnSettingsChanged(b Bus, fn func(*SettingsChanged)) error {
+	return b.Subscribe(TopicSettingsChanged, func(payload []byte) {
+		m := &SettingsChanged{}
+		if json.Unmarshal(payload, m) == nil {
+			fn(m)
+		}
+	})
+}
+
+// NowPlayingQuery asks what the bot plays in a guild, answered by NowPlaying
+type NowPlayingQuery struct {
+	GuildID string `json:"guildId"`
+}
+
+// Topic of the query
+func (*NowPlayingQuery) Topic() string { return TopicNowPlaying }
+
+// NowPlaying is the sound playing in a guild and the ones waiting
+type NowPlaying struct {
+	// Name of the sound playing, empty if none is
+	Sound string `json:"sound,omitempty"`
+	// User who requested it, empty for scheduled sounds
+	UserID string `json:"userId,omitempty"`
+	// Names of the sounds waiting
+	Queue []string `json:"queue"`
+}
+
+// HandleNowPlaying answers the NowPlayingQuery queries routed to a shard with
+// fn, fn returns nil for the guilds it doesn't serve
+func HandleNowPlaying(b Bus, s Shard, fn func(*NowPlayingQuery) *NowPlaying) error {
+	return b.Handle(s.Topic(TopicNowPlaying), func(payload []byte) interface{} {
+		q := &NowPlayingQuery{}
+		if json.Unmarshal(payload, q) != nil {
+			return nil
+		}
+		if r := fn(q); r != nil {
+			return r
+		}
+		return nil
+	})
+}
+
+// VoiceStatusQuery asks whether the bot is in a voice channel of a guild,
+// answered by VoiceStatus
+type VoiceStatusQuery struct {
+	GuildID string `json:"guildId"`
+}
+
+// Topic of the query
+func (*VoiceStatusQuery) Topic() string { return TopicVoiceStatus }
+
+// VoiceStatus is the voice channel of the bot in a guild
+type VoiceStatus struct {
+	Connected   bool   `json:"connected"`
+	ChannelID   string `json:"channelId,omitempty"`
+	ChannelName string `json:"channelName,omitempty"`
+	// Users in the channel, bots aside
+	Listeners int `json:"listeners"`
+}
+
+// HandleVoiceStatus answers the VoiceStatusQuery queries routed to a shard with
+// fn, fn returns nil for the guilds it doesn't serve
+func HandleVoiceStatus(b Bus, s Shard, fn func(*VoiceStatusQuery) *VoiceStatus) error {
+	return b.Handle(s.Topic(TopicVoiceStatus), func(payload []byte) interface{} {
+		q := &VoiceStatusQuery{}
+		if json.Unmarshal(payload, q) != nil {
+			return nil
+		}
+		if r := fn(q); r != nil {
+			return r
+		}
+		return nil
+	})
+}
+
+// PlaySoundQuery asks the bot to play a sound of a guild in the voice channel
+// of a user, either a custom sound of the guild or a default sound. It is
+// answered by PlaySoundResult.
+type PlaySoundQuery struct {
+	GuildID     string `json:"guildId"`
+	UserID      string `json:"userId"`
+	SoundID     string `json:"soundId,omitempty"`
+	DefaultName string `json:"defaultName,omitempty"`
+}
+
+// Topic of the query
+func (*PlaySoundQuery) Topic() string { return TopicPlaySound }
+
+// PlaySoundResult tells what the bot did with a PlaySoundQuery
+type PlaySoundResult struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// HandlePlaySound answers the PlaySoundQuery queries routed to a shard with fn,
+// fn returns nil for the guilds it doesn't serve
+func HandlePlaySound(b Bus, s Shard, fn func(*PlaySoundQuery) *PlaySoundResult) error {
+	return b.Handle(s.Topic(TopicPlaySound), func(payload []byte) interface{} {
+		q := &PlaySoundQuery{}
+		if json.Unmarshal(payload, q) != nil {
+			return nil
+		}
+		if r := fn(q); r != nil {
+			return r
+		}
+		return nil
+	})
+}
@@ -0,0 +1,287 @@
+package bus
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/garyburd/redigo/redis"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Prefix of the redis channels of the topics
+const channelPrefix = "airhorn:bus:"
+
+// Time the answer to a query is kept in redis, in seconds
+const replyTTL = 30
+
+// Time waited before subscribing again when the connection is lost
+const reconnectDelay = 5 * time.Second
+
+// Pushed by the processes with no answer to a query, answers are never empty
+const noAnswer = ""
+
+// envelope wraps the messages sent over redis
+type envelope struct {
+	// ID and key of the list the answer of a query is pushed to, empty for
+	// events
+	ReplyTo string          `json:"replyTo,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Redis is a bus over redis pub/sub, between processes
+type Redis struct {
+	pool *redis.Pool
+
+	mu       sync.Mutex
+	psc      *redis.PubSubConn
+	closed   bool
+	subs     map[string][]func([]byte)
+	handlers map[string][]func([]byte) interface{}
+	done     chan struct{}
+}
+
+// NewRedis returns a bus over the redis server of pool, it subscribes to the
+// topics in the background until it is closed
+func NewRedis(pool *redis.Pool) *Redis {
+	r := &Redis{
+		pool:     pool,
+		subs:     make(map[string][]func([]byte)),
+		handlers: make(map[string][]func([]byte) interface{}),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Publish sends an event to the subscribers of its topic, in every process
+func (r *Redis) Publish(m Message) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(&envelope{Payload: payload})
+	if err != nil {
+		return err
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("PUBLISH", channelPrefix+m.Topic(), b)
+	return err
+}
+
+// Subscribe calls fn with every event of a topic
+func (r *Redis) Subscribe(topic string, fn func(payload []byte)) error {
+	r.mu.Lock()
+	r.subs[topic] = append(r.subs[topic], fn)
+	r.mu.Unlock()
+	return r.subscribe(topic)
+}
+
+// Handle answers the queries of a topic with fn
+func (r *Redis) Handle(topic string, fn func(payload []byte) interface{}) error {
+	r.mu.Lock()
+	r.handlers[topic] = append(r.handlers[topic], fn)
+	r.mu.Unlock()
+	return r.subscribe(topic)
+}
+
+// Request sends a query to every process and decodes the first answer in
+// reply, it returns ErrNoHandler if they all have no answer. Redis waits by
+// seconds, the timeout is rounded up.
+func (r *Redis) Request(q Message, reply interface{}, timeout time.Duration) error {
+	payload, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	replyTo := channelPrefix + "reply:" + uuid.NewV4().String()
+	b, err := json.Marshal(&envelope{ReplyTo: replyTo, Payload: payload})
+	if err != nil {
+		return err
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	receivers, err := redis.Int(conn.Do("PUBLISH", channelPrefix+q.Topic(), b))
+	if err != nil {
+		return err
+	} else if receivers == 0 {
+		return ErrNoHandler
+	}
+
+	deadline := time.Now().Add(timeout)
+	for declined := 0; declined < receivers; declined++ {
+		seconds := int((deadline.Sub(time.Now()) + time.Second - 1) / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+		answer, err := redis.ByteSlices(conn.Do("BLPOP", replyTo, seconds))
+		if err == redis.ErrNil {
+			return ErrTimeout
+		} else if err != nil {
+			return err
+		}
+		if string(answer[1]) != noAnswer {
+			return json.Unmarshal(answer[1], reply)
+		}
+	}
+	return ErrNoHandler
+}
+
+// Close stops the subscription, the connection goes back to the pool once
+// redis confirms it
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+
+	if r.psc != nil {
+		return r.psc.Unsubscribe()
+	}
+	return nil
+}
+
+// subscribe subscribes to a topic on the current connection, the topics are
+// subscribed to again when it is replaced
+func (r *Redis) subscribe(topic string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	if r.psc == nil {
+		return nil
+	}
+	return r.psc.Subscribe(channelPrefix + topic)
+}
+
+// run receives the messages of the subscribed topics until the bus is closed
+func (r *Redis) run() {
+	for {
+		err := r.receive()
+
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+		log.WithError(err).Warn("Lost the connection to the message bus, retrying")
+
+		select {
+		case <-r.done:
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (r *Redis) receive() error {
+	psc := &redis.PubSubConn{Conn: r.pool.Get()}
+	defer psc.Close()
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrClosed
+	}
+	var channels []interface{}
+	for topic := range r.subs {
+		channels = append(channels, channelPrefix+topic)
+	}
+	for topic := range r.handlers {
+		if _, ok := r.subs[topic]; !ok {
+			channels = append(channels, channelPrefix+topic)
+		}
+	}
+	if len(channels) > 0 {
+		if err := psc.Subscribe(channels...); err != nil {
+			r.mu.Unlock()
+			return err
+		}
+	}
+	// only one goroutine may send on the connection, subscribe does it with
+	// the lock held
+	r.psc = psc
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.psc = nil
+		r.mu.Unlock()
+	}()
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			r.dispatch(v.Channel[len(channelPrefix):], v.Data)
+		case redis.Subscription:
+			if v.Kind == "unsubscribe" && v.Count == 0 {
+				return ErrClosed
+			}
+		case error:
+			return v
+		}
+	}
+}
+
+// dispatch gives a message to the subscribers or the handlers of its topic
+func (r *Redis) dispatch(topic string, data []byte) {
+	e := &envelope{}
+	if err := json.Unmarshal(data, e); err != nil {
+		log.WithFields(log.Fields{
+			"topic": topic,
+			"error": err,
+		}).Warn("Invalid message on the bus")
+		return
+	}
+
+	r.mu.Lock()
+	subs := r.subs[topic]
+	handlers := r.handlers[topic]
+	r.mu.Unlock()
+
+	if e.ReplyTo == "" {
+		for _, fn := range subs {
+			go fn(e.Payload)
+		}
+		return
+	}
+	go r.answer(e.ReplyTo, e.Payload, handlers)
+}
+
+// answer pushes the first answer of the handlers of this process to a query,
+// or noAnswer if they have none, so the requester doesn't wait for it
+func (r *Redis) answer(replyTo string, payload []byte, handlers []func([]byte) interface{}) {
+	answers := make(chan interface{}, len(handlers))
+	for _, fn := range handlers {
+		go func(fn func([]byte) interface{}) {
+			answers <- fn(payload)
+		}(fn)
+	}
+
+	b := []byte(noAnswer)
+	for range handlers {
+		if answer := <-answers; answer != nil {
+			if encoded, err := json.Marshal(answer); err == nil {
+				b = encoded
+				break
+			}
+		}
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+	conn.Send("MULTI")
+	conn.Send("RPUSH", replyTo, b)
+	conn.Send("EXPIRE", replyTo, replyTTL)
+	if _, err := conn.Do("EXEC"); err != nil {
+		log.WithError(err).Warn("Couldn't answer a query on the bus")
+	}
+}
@@ -0,0 +1,64 @@
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Shard is one of the parts the guilds of the bot are split in, each served
+// by its own gateway connection
+type Shard struct {
+	ID    int
+	Count int
+}
+
+// ShardOf returns the shard of a guild among count shards, the way Discord
+// splits them
+func ShardOf(guildID string, count int) int {
+	if count <= 1 {
+		return 0
+	}
+	id, err := strconv.ParseUint(guildID, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return int((id >> 22) % uint64(count))
+}
+
+// Owns reports whether a guild belongs to the shard
+func (s Shard) Owns(guildID string) bool {
+	return s.Count <= 1 || ShardOf(guildID, s.Count) == s.ID
+}
+
+// Topic returns the topic of the queries answered by the shard, the topic
+// itself when the bot isn't sharded
+func (s Shard) Topic(topic string) string {
+	if s.Count <= 1 {
+		return topic
+	}
+	return fmt.Sprintf("%s.%d", topic, s.ID)
+}
+
+// Route returns q sent to the shard serving a guild among count shards only
+func Route(q Message, guildID string, count int) Message {
+	if count <= 1 {
+		return q
+	}
+	s := Shard{ID: ShardOf(guildID, count), Count: count}
+	return &routed{Message: q, topic: s.Topic(q.Topic())}
+}
+
+// routed is a query sent to a single shard
+type routed struct {
+	Message
+	topic string
+}
+
+func (r *routed) Topic() string {
+	return r.topic
+}
+
+func (r *routed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Message)
+}
@@ -2,14 +2,12 @@ package main
 
 import (
 	"bytes"
-	"errors"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"math/rand"
+	"math"
+	"net/url"
 	"os"
-	"os/signal"
-	"plugin"
 	"runtime"
 	"strconv"
 	"strings"
@@ -22,6 +20,10 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/garyburd/redigo/redis"
 	"github.com/jonas747/dca"
+	"gitlab.com/Shywim/airhornbot/bus"
+	"gitlab.com/Shywim/airhornbot/codec"
+	"gitlab.com/Shywim/airhornbot/codec/opus"
+	"gitlab.com/Shywim/airhornbot/pluginapi"
 	"gitlab.com/Shywim/airhornbot/service"
 )
 
@@ -32,12 +34,25 @@ var (
 	// Redis client connection (used for stats)
 	redisPool *redis.Pool
 
-	// Map of Guild id's to *Play channels, used for queuing and rate-limiting guilds
+	// Bus the web app sends its events and queries on
+	messageBus bus.Bus
+
+	// Map of Guild id's to *guildQueue, used for queuing and rate-limiting guilds
 	queues = sync.Map{}
 
-	// Map of Guild id's to disconnect timers
+	// Map of Guild id's to disconnect timers, a guild has one only while the
+	// bot waits in its channel with nothing to play
 	dcTimers = sync.Map{}
 
+	// Serializes the changes of the queues and the disconnect timers
+	queuesLock sync.Mutex
+
+	// Map of Guild id's to the *playback of the sound playing
+	playbacks = sync.Map{}
+
+	// Map of Guild id's to the *mixer playing in guilds mixing their sounds
+	mixers = sync.Map{}
+
 	// Sound encoding settings
 	bitrate = 128
 
@@ -51,23 +66,27 @@ var (
 	cfg     service.Cfg
 )
 
-type airhornPlugin struct {
-	name     string
-	handle   func(string) bool
-	getSound func(string) [][]byte
-}
-
 type play struct {
 	GuildID   string
 	ChannelID string
 	UserID    string
 	Sound     *service.Sound
 
+	// Text channel the play was requested from, the gif of the sound is sent
+	// there. Empty for the plays nobody typed, like walk-on sounds.
+	TextChannelID string
+
 	// The next play to occur after this, only used for chaining sounds like anotha
 	Next *play
 
 	// If true, this was a forced play using a specific airhorn sound name
 	Forced bool
+
+	// Words following the command, given to plugins
+	Args []string
+
+	// Effects given as command modifiers, added to the sound's own effects
+	Effects codec.Effects
 }
 
 // Create a Sound struct
@@ -80,23 +99,7 @@ func createSound(Name string, Weight int, Gif string) *service.Sound {
 }
 
 func random(s []*service.Sound) *service.Sound {
-	var (
-		i     int
-		total int
-	)
-
-	for _, sound := range s {
-		total += sound.Weight
-	}
-	number := randomRange(0, total)
-	for _, sound := range s {
-		i += sound.Weight
-
-		if number < i {
-			return sound
-		}
-	}
-	return nil
+	return service.PickRandom(s)
 }
 
 // Attempt to join a voice channel
@@ -130,54 +133,177 @@ func getCurrentVoiceChannel(user *discordgo.User, guild *discordgo.Guild) *disco
 	return nil
 }
 
-// Returns a random integer between min and max
-func randomRange(min, max int) int {
-	rand.Seed(time.Now().UTC().UnixNano())
-	return rand.Intn(max-min) + min
+// Duration of an opus frame
+const frameDuration = 20 * time.Millisecond
+
+// Number of sounds with effects kept in memory
+const effectsCacheSize = 64
+
+// Sounds with effects applied, by file and effects
+var effectsCache = opus.NewCache(effectsCacheSize)
+
+// Gains smaller than this are not applied, they can't be heard and would cost
+// a re-encoding of the sound
+const minAudibleGain = 0.5
+
+// openSound opens the sound of a play as a stream of opus frames, plugin
+// sounds stop once ctx is done
+func openSound(ctx context.Context, p *play) (pluginapi.Stream, error) {
+	s := p.Sound
+	volume := guildVolumeGain(p.GuildID)
+	if strings.HasPrefix(s.FilePath, pluginPathPrefix) {
+		// plugin sounds are streamed, effects can't be applied to them
+		stream, err := openPluginSound(ctx, p)
+		if err != nil || math.Abs(volume) < minAudibleGain {
+			return stream, err
+		}
+		return newGainStream(stream, volume)
+	}
+
+	path := service.AudioPath(s)
+	effects := p.Effects
+	if s.Effects != "" {
+		defaults, err := codec.ParseEffects(s.Effects)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"sound":   s.Name,
+				"effects": s.Effects,
+				"error":   err,
+			}).Warn("Invalid sound effects, ignoring them")
+		}
+		effects = defaults.Merge(p.Effects)
+	}
+
+	// the normalization of the sound and the volume of the guild
+	if gain := s.Gain + volume; math.Abs(gain) >= minAudibleGain {
+		effects.Gain = math.Max(codec.MinGain, math.Min(codec.MaxGain, effects.Gain+gain))
+	}
+
+	if !effects.IsZero() {
+		frames, err := effectsCache.Process(path, effects, func() ([][]byte, error) {
+			return readSoundFile(path)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return pluginapi.FramesStream(frames), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStream{file: file, decoder: dca.NewDecoder(file)}, nil
+}
+
+// guildVolumeGain returns the gain in dB of the master volume of a guild
+func guildVolumeGain(guildID string) float64 {
+	settings, err := service.GetGuildSettings(guildID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"guild": guildID,
+			"error": err,
+		}).Warn("Couldn't get the guild settings, playing at full volume")
+		return 0
+	}
+	return codec.VolumeGain(settings.Volume)
 }
 
-func loadSoundFromPlugin(pluginName, name string) (buffer [][]byte, err error) {
-	plugin := plugins[pluginName]
-	if plugin == nil {
-		return nil, errors.New("Couldn't find a matching plugin for sound")
+// readSoundFile reads every frame of a dca file
+func readSoundFile(path string) (frames [][]byte, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	stream := &fileStream{file: file, decoder: dca.NewDecoder(file)}
+	defer stream.Close()
 
-	soundData := plugin.getSound(name)
-	if soundData == nil {
-		return nil, errors.New("Failed to get sound from plugin")
+	for {
+		frame, err := stream.NextFrame()
+		if err == io.EOF {
+			return frames, nil
+		} else if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
 	}
+}
 
-	return soundData, nil
+// fileStream reads the opus frames of a dca file
+type fileStream struct {
+	file    *os.File
+	decoder *dca.Decoder
 }
 
-func loadSound(s *service.Sound) (buffer [][]byte, err error) {
-	if strings.HasPrefix(s.FilePath, "@plugin/") {
-		return loadSoundFromPlugin(strings.TrimPrefix(s.FilePath, "@plugin/"), s.Name)
+func (s *fileStream) NextFrame() ([]byte, error) {
+	frame, err := s.decoder.OpusFrame()
+	if err == io.ErrUnexpectedEOF {
+		return nil, io.EOF
 	}
+	return frame, err
+}
+
+func (s *fileStream) Close() error {
+	return s.file.Close()
+}
+
+// gainStream changes the volume of a stream frame by frame
+type gainStream struct {
+	pluginapi.Stream
+	gainer *opus.Gainer
+}
 
-	file, err := os.Open(cfg.DataPath + string(os.PathSeparator) + s.FilePath)
+func newGainStream(stream pluginapi.Stream, gain float64) (pluginapi.Stream, error) {
+	gainer, err := opus.NewGainer(gain)
 	if err != nil {
+		stream.Close()
 		return nil, err
 	}
+	return &gainStream{Stream: stream, gainer: gainer}, nil
+}
 
-	decoder := dca.NewDecoder(file)
+func (s *gainStream) NextFrame() ([]byte, error) {
+	frame, err := s.Stream.NextFrame()
+	if err != nil {
+		return nil, err
+	}
+	return s.gainer.Frame(frame)
+}
 
-	for {
-		frame, err := decoder.OpusFrame()
-		if err != nil {
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				return buffer, nil
-			}
+// playback is the sound playing in a guild
+type playback struct {
+	cancel context.CancelFunc
+}
 
-			fmt.Println("error reading from dca file :", err)
-			return nil, err
+// newPlayContext returns the context of a sound playing in a guild, cancelled
+// by skipSound or by calling the returned function once the sound is over
+func newPlayContext(gID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pb := &playback{cancel: cancel}
+	playbacks.Store(gID, pb)
+
+	return ctx, func() {
+		cancel()
+		if cur, ok := playbacks.Load(gID); ok && cur == pb {
+			playbacks.Delete(gID)
 		}
+	}
+}
 
-		buffer = append(buffer, frame)
+// skipSound interrupts the sound playing in a guild, it returns false if there
+// is none
+func skipSound(gID string) bool {
+	pb, ok := playbacks.Load(gID)
+	if !ok {
+		return false
 	}
+	pb.(*playback).cancel()
+	return true
 }
 
-func doPlay(soundData [][]byte, vc *discordgo.VoiceConnection) {
+// doPlay sends the frames of a stream until its end, the maximum sound
+// duration or ctx is done
+func doPlay(ctx context.Context, stream pluginapi.Stream, vc *discordgo.VoiceConnection) {
 	_ = vc.Speaking(true)
 	defer func() {
 		err := vc.Speaking(false)
@@ -186,9 +312,29 @@ func doPlay(soundData [][]byte, vc *discordgo.VoiceConnection) {
 		}
 	}()
 
-	for _, buff := range soundData {
-		vc.OpusSend <- buff
+	maxFrames := int(cfg.MaxSoundDuration / frameDuration)
+	for i := 0; maxFrames <= 0 || i < maxFrames; i++ {
+		frame, err := stream.NextFrame()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).Error("Error reading sound")
+			}
+			return
+		}
+
+		select {
+		case vc.OpusSend <- frame:
+		case <-ctx.Done():
+			return
+		}
 	}
+
+	log.WithFields(log.Fields{
+		"guildId":  vc.GuildID,
+		"duration": cfg.MaxSoundDuration,
+	}).Info("Sound cut at the maximum duration")
 }
 
 // Prepares a play
@@ -217,33 +363,61 @@ func createPlay(user *discordgo.User, guild *discordgo.Guild, coll []*service.So
 }
 
 // Prepares and enqueues a play into the ratelimit/buffer guild queue
-func enqueuePlay(user *discordgo.User, guild *discordgo.Guild, sounds []*service.Sound, cid string) {
+func enqueuePlay(user *discordgo.User, guild *discordgo.Guild, sounds []*service.Sound, cid string, args []string) {
 	p := createPlay(user, guild, sounds)
 	if p == nil {
 		return
 	}
+	p.TextChannelID = cid
+	p.Effects, p.Args = codec.ParseModifiers(args)
+	queuePlay(p)
+}
 
-	// Check if we already have a connection to this guild
-	tmp, ok := queues.Load(guild.ID)
+// Plays a play or puts it in the guild queue
+func queuePlay(p *play) {
+	guildID := p.GuildID
 
-	if ok && tmp != nil {
-		queue, _ := tmp.(chan *play)
+	// the sounds playing are ending before the bot stops
+	if isDraining() {
+		log.WithFields(log.Fields{
+			"guildId": guildID,
+			"sound":   p.Sound.Name,
+		}).Info("Shutting down, dropping sound")
+		return
+	}
 
-		t, ok := dcTimers.Load(guild.ID)
-		if ok && t != nil {
-			// if the bot is waiting to disconnect, relaunch the queue immediately
-			timer := t.(*time.Timer)
-			if !timer.Stop() {
-				queues.Store(guild.ID, make(chan *play, maxQueueSize))
-			}
-			playSound(p, nil, cid)
-		} else if len(queue) < maxQueueSize {
-			// else put the play in queue
-			queue <- p
-		}
-	} else {
-		queues.Store(guild.ID, make(chan *play, maxQueueSize))
-		playSound(p, nil, cid)
+	// In mix mode, play the sound over the one playing
+	if mixSound(p) {
+		return
+	}
+
+	queuesLock.Lock()
+	queue := getQueue(guildID)
+	if queue == nil {
+		// the bot isn't in the guild, join and play
+		queue = newGuildQueue()
+		startPlay(queue, p)
+		queues.Store(guildID, queue)
+		queuesLock.Unlock()
+		playSound(p, nil)
+		return
+	}
+	if stopDisconnect(guildID) {
+		// the bot is waiting to disconnect, play right away
+		startPlay(queue, p)
+		queuesLock.Unlock()
+		playSound(p, currentVoice(guildID))
+		return
+	}
+	// a sound is playing, wait for it
+	pushed := queue.push(p)
+	queuesLock.Unlock()
+
+	if !pushed {
+		log.WithFields(log.Fields{
+			"guildId": guildID,
+			"sound":   p.Sound.Name,
+		}).Info("Queue full, dropping sound")
 	}
 }
 
@@ -276,8 +450,11 @@ func trackSoundStats(p *play) {
 	if err = conn.Send("INCR", fmt.Sprintf("airhorn:guild:%s:soundstats:%s", p.GuildID, redisSoundID)); err != nil {
 		log.WithError(err).Warning("failed to increment guild play count in redis")
 	}
-	if err = conn.Send("SAdd", fmt.Sprintf("airhorn:users"), p.UserID); err != nil {
-		log.WithError(err).Warning("failed to increment user count in redis")
+	// scheduled sounds have no user
+	if p.UserID != "" {
+		if err = conn.Send("SAdd", fmt.Sprintf("airhorn:users"), p.UserID); err != nil {
+			log.WithError(err).Warning("failed to increment user count in redis")
+		}
 	}
 	if err = conn.Send("SAdd", fmt.Sprintf("airhorn:guilds"), p.GuildID); err != nil {
 		log.WithError(err).Warning("failed to increment guilds count in redis")
@@ -293,15 +470,24 @@ func trackSoundStats(p *play) {
 }
 
 // Play a sound
-func playSound(p *play, vc *discordgo.VoiceConnection, cid string) {
+func playSound(p *play, vc *discordgo.VoiceConnection) {
 	log.WithFields(log.Fields{
 		"p": p,
 	}).Info("Playing sound")
 
-	// load sound file
-	soundData, err := loadSound(p.Sound)
+	queue := getQueue(p.GuildID)
+
+	// open the sound before connecting, plugins may fail to start it
+	ctx, done := newPlayContext(p.GuildID)
+	stream, err := openSound(ctx, p)
 	if err != nil {
+		done()
 		log.WithError(err).Error("Failed to read sound file")
+		if vc == nil {
+			forgetQueue(p.GuildID, queue)
+			return
+		}
+		playNext(vc, p.GuildID)
 		return
 	}
 
@@ -310,7 +496,9 @@ func playSound(p *play, vc *discordgo.VoiceConnection, cid string) {
 
 		if err != nil {
 			log.WithError(err).Error("Failed to play sound")
-			queues.Delete(p.GuildID)
+			stream.Close()
+			done()
+			forgetQueue(p.GuildID, queue)
 
 			err = vc.Disconnect()
 			if err != nil {
@@ -321,10 +509,13 @@ func playSound(p *play, vc *discordgo.VoiceConnection, cid string) {
 	}
 
 	// If we need to change channels, do that now
-	if vc.ChannelID != p.ChannelID {
+	if voiceChannelID(vc) != p.ChannelID {
 		err = vc.ChangeChannel(p.ChannelID, false, false)
 		if err != nil {
 			log.WithError(err).Error("Failed to connect to voice channel")
+			stream.Close()
+			done()
+			forgetQueue(p.GuildID, queue)
 			err = vc.Disconnect()
 			if err != nil {
 				log.WithError(err).Error("Failed to disconnect from voice channel")
@@ -335,63 +526,128 @@ func playSound(p *play, vc *discordgo.VoiceConnection, cid string) {
 	}
 
 	// Track stats for this p in redis
-	go trackSoundStats(p)
+	trackStats(p)
 
 	// Sleep for a specified amount of time before ping the sound
 	time.Sleep(time.Millisecond * 32)
 
 	// Send gif if present
-	if p.Sound.Gif != "" {
-		_, err = discord.ChannelMessageSend(cid, p.Sound.Gif)
+	if p.Sound.Gif != "" && p.TextChannelID != "" {
+		_, err = discord.ChannelMessageSend(p.TextChannelID, p.Sound.Gif)
 		if err != nil {
 			log.WithError(err).Warning("Failed to send gif to text channel")
 		}
 	}
 
-	// Play the sound
-	doPlay(soundData, vc)
+	// Play the sound, the mixer closes the sounds it plays
+	if guildMixes(p.GuildID) {
+		mixSounds(ctx, stream, vc)
+	} else {
+		doPlay(ctx, stream, vc)
+		if err = stream.Close(); err != nil {
+			log.WithError(err).Warning("Error closing sound")
+		}
+	}
+	done()
 
-	// If there is another song in the queue, recurse and p that
-	tmp, exists := queues.Load(p.GuildID)
+	playNext(vc, p.GuildID)
+}
 
-	if exists {
-		queue := tmp.(chan *play)
-		if len(queue) > 0 {
-			p := <-queue
-			playSound(p, vc, cid)
-			return
-		}
+// Plays the next sound of the guild queue, or waits before disconnecting if
+// the queue is empty
+func playNext(vc *discordgo.VoiceConnection, gID string) {
+	if currentVoice(gID) != vc {
+		// the bot left the channel while the sound played
+		return
 	}
 
-	// If the queue is empty, delete it
-	endQueue(vc, p.GuildID)
-	return
+	queuesLock.Lock()
+	queue := getQueue(gID)
+	if queue == nil {
+		queuesLock.Unlock()
+		return
+	}
+	queue.setCurrent(nil)
+	p := queue.pop()
+	if p != nil {
+		startPlay(queue, p)
+	} else {
+		endQueue(vc, gID)
+	}
+	queuesLock.Unlock()
+
+	if p != nil {
+		playSound(p, vc)
+	}
+}
+
+// startPlay makes a play the sound playing in its guild, it follows its
+// requester if they moved since the sound was requested. queuesLock must be
+// held.
+func startPlay(queue *guildQueue, p *play) {
+	if channelID := userVoiceChannelID(p.GuildID, p.UserID); channelID != "" {
+		p.ChannelID = channelID
+	}
+	queue.setCurrent(p)
 }
 
+// forgetQueue removes the queue of a guild the bot couldn't play in, unless
+// it was already replaced
+func forgetQueue(gID string, queue *guildQueue) {
+	queuesLock.Lock()
+	defer queuesLock.Unlock()
+	if queue != nil && getQueue(gID) == queue {
+		queues.Delete(gID)
+	}
+}
+
+// disconnect leaves the voice channel of a guild once its timer fired, unless
+// a sound was queued meanwhile
 func disconnect(timer *time.Timer, vc *discordgo.VoiceConnection, gID string) {
+	queuesLock.Lock()
+	if t, ok := dcTimers.Load(gID); !ok || t != timer {
+		queuesLock.Unlock()
+		return
+	}
+	dcTimers.Delete(gID)
 	queues.Delete(gID)
+	queuesLock.Unlock()
+
 	voiceDisconnect(vc)
-	dcTimers.Delete(timer)
 }
 
+// endQueue starts a new timer disconnecting from the voice channel of a guild
+// if nothing is played meanwhile, queuesLock must be held
 func endQueue(vc *discordgo.VoiceConnection, gID string) {
-	t, ok := dcTimers.Load(gID)
-	if ok && t != nil {
-		timer := t.(*time.Timer)
-		if timer.Stop() {
-			timer.Reset(5 * time.Minute)
-		}
-		return
-	}
+	stopDisconnect(gID)
 
 	var timer *time.Timer
 	timer = time.AfterFunc(5*time.Minute, func() { disconnect(timer, vc, gID) })
 	dcTimers.Store(gID, timer)
 }
 
+// stopDisconnect stops and forgets the disconnect timer of a guild, it reports
+// whether the bot was waiting to disconnect. queuesLock must be held.
+func stopDisconnect(gID string) bool {
+	t, ok := dcTimers.Load(gID)
+	if !ok {
+		return false
+	}
+	dcTimers.Delete(gID)
+	// a timer which already fired finds it was forgotten and doesn't
+	// disconnect
+	t.(*time.Timer).Stop()
+	return true
+}
+
 func onReady(s *discordgo.Session, event *discordgo.Ready) {
 	log.Info("Recieved READY payload")
-	err := s.UpdateStatus(0, "airhorn.shywim.fr")
+	status := cfg.BaseURL
+	if u, err := url.Parse(cfg.BaseURL); err == nil && u.Host != "" {
+		status = u.Host
+	}
+
+	err := s.UpdateStatus(0, status)
 	if err != nil {
 		log.WithError(err).Warning("Couldn't set status line")
 	}
@@ -424,6 +680,7 @@ func displayBotStats(cid string) {
 	fmt.Fprintf(w, "Go: \t%s\n", runtime.Version())
 	fmt.Fprintf(w, "Memory: \t%s / %s (%s total allocated)\n", humanize.Bytes(stats.Alloc), humanize.Bytes(stats.Sys), humanize.Bytes(stats.TotalAlloc))
 	fmt.Fprintf(w, "Tasks: \t%d\n", runtime.NumGoroutine())
+	fmt.Fprintf(w, "Shard: \t%d / %d\n", shard.ID+1, shard.Count)
 	fmt.Fprintf(w, "Servers: \t%d\n", len(discord.State.Ready.Guilds))
 	fmt.Fprintf(w, "Users: \t%d\n", users)
 	fmt.Fprintf(w, "```\n")
@@ -439,34 +696,65 @@ func displayBotStats(cid string) {
 	}
 }
 
-/*func displayBotCommands(cid string) {
+// Maximum length of a discord message
+const maxMessageLength = 2000
+
+// Sends the list of commands available in a guild
+func displayBotCommands(cid, gid string) {
+	pools, err := service.GetCommandPoolsByGuild(gid)
+	if err != nil {
+		log.WithError(err).Error("Error retrieving guild sounds")
+		return
+	}
+
 	w := &tabwriter.Writer{}
 	buf := &bytes.Buffer{}
 
 	w.Init(buf, 0, 4, 0, ' ', 0)
 	fmt.Fprint(w, "```\n")
-	for _, coll := range COLLECTIONS {
-		if len(coll.Commands) == 1 {
-			fmt.Fprintf(w, "%s: ", coll.Commands[0])
-		} else {
-			for i, comm := range coll.Commands {
-				if i == 0 {
-					fmt.Fprintf(w, "%s", comm)
-				} else {
-					fmt.Fprintf(w, ", %s", comm)
-				}
+	fmt.Fprint(w, "!skip: \tSkips the sound playing\n")
+	fmt.Fprint(w, "!stop: \tSkips the sound playing and clears the queue\n")
+	fmt.Fprint(w, "!clear: \tClears the queue\n")
+	fmt.Fprint(w, "!queue: \tLists the sounds waiting to play\n")
+	for _, pool := range pools {
+		fmt.Fprintf(w, "!%s: \t", pool.Command)
+		for i, sound := range pool.Sounds {
+			if i > 0 {
+				fmt.Fprint(w, ", ")
 			}
-			fmt.Fprint(w, ": ")
+			fmt.Fprint(w, sound.Name)
 		}
-
-		for _, sound := range coll.Sounds {
-			fmt.Fprintf(w, "\t%s\n", sound.Name)
+		fmt.Fprint(w, "\n")
+	}
+	for _, p := range guildPlugins(gid) {
+		for _, c := range p.commands {
+			fmt.Fprintf(w, "!%s: \t%s\n", c.Name, c.Description)
 		}
 	}
 	fmt.Fprint(w, "```\n")
-	w.Flush()
-	discord.ChannelMessageSend(cid, buf.String())
-}*/
+	err = w.Flush()
+	if err != nil {
+		log.WithError(err).Error("Error while building help message")
+		return
+	}
+
+	// members play sounds from the soundboard of the web app
+	board := ""
+	if redisPool != nil && cfg.BaseURL != "" {
+		board = fmt.Sprintf("Soundboard: <%s/guild/%s/board>\n", strings.TrimSuffix(cfg.BaseURL, "/"), gid)
+	}
+
+	msg := buf.String()
+	if len(msg)+len(board) > maxMessageLength {
+		msg = msg[:maxMessageLength-len(board)-len("...\n```\n")] + "...\n```\n"
+	}
+	msg += board
+
+	_, err = discord.ChannelMessageSend(cid, msg)
+	if err != nil {
+		log.WithError(err).Error("Error while sending help message")
+	}
+}
 
 func utilSumRedisKeys(keys []string) (int, error) {
 	var total int64
@@ -569,35 +857,28 @@ func handleBotControlMessages(s *discordgo.Session, m *discordgo.MessageCreate,
 		}
 	} else if scontains(parts[1], "bomb") && len(parts) >= 4 {
 		airhornBomb(m.ChannelID, g, utilGetMentioned(s, m), parts[3])
+	} else if scontains(parts[1], "plugins") {
+		handlePluginsCommand(m.ChannelID, parts[2:])
 	}
 }
 
 func handleMentionMessages(s *discordgo.Session, m *discordgo.MessageCreate, parts []string, g *discordgo.Guild) {
 	if scontains(parts[1], "help") {
-		// TODO: help
-		//displayBotCommands(m.ChannelID)
+		displayBotCommands(m.ChannelID, g.ID)
 	}
 }
 
-func findPluginForSound(name string) (sounds []*service.Sound) {
-	for _, p := range plugins {
-		if p.handle(name) {
-			sound := &service.Sound{
-				FilePath: "@plugin/" + p.name,
-				Name:     name,
-				Weight:   1,
-			}
-			sounds = append(sounds, sound)
-		}
-	}
-
-	return
-}
-
 func onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if len(m.Content) <= 0 || (m.Content[0] != '!' && len(m.Mentions) < 1) {
+	if len(m.Content) <= 0 || m.Author == nil || m.Author.ID == s.State.User.ID {
 		return
 	}
+	if m.Content[0] != '!' {
+		// the messages mentioning someone can set off triggers too
+		playMessageTriggers(s, m)
+		if len(m.Mentions) < 1 {
+			return
+		}
+	}
 
 	msg := strings.Replace(m.ContentWithMentionsReplaced(), s.State.Ready.User.Username, "username", 1)
 	parts := strings.Split(strings.ToLower(msg), " ")
@@ -648,85 +929,41 @@ func onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	}
 
 	command := strings.TrimPrefix(parts[0], "!")
-
-	// filter default sounds
-	sounds := service.FilterByCommand(command, service.DefaultSounds)
-	guildSounds, err := service.GetSoundsByCommand(command, channel.GuildID)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error":   err,
-			"guildId": channel.GuildID,
-		}).Warn("Couldn't get sounds from db")
+	if handleQueueCommand(m, guild, command) {
+		return
 	}
-	sounds = append(sounds, guildSounds...)
-
-	// check plugins
-	sounds = append(sounds, findPluginForSound(command)...)
 
 	// if we found at least one sound, play it or them
-	if len(sounds) > 0 {
-		go enqueuePlay(m.Author, guild, sounds, m.ChannelID)
+	if sounds := findSounds(command, channel.GuildID); len(sounds) > 0 {
+		go enqueuePlay(m.Author, guild, sounds, m.ChannelID, parts[1:])
 	} else {
 		log.WithField("sound", command).Info("No sound found for this command")
 	}
 }
 
-func loadPlugins(pluginsPath string) {
-	files, err := ioutil.ReadDir(pluginsPath)
+// findSounds returns the sounds a command plays in a guild: its default sounds
+// as configured in the guild, its own sounds and the plugins' sounds
+func findSounds(command, guildID string) []*service.Sound {
+	defaults, err := service.GetDefaultSoundsByGuild(guildID)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Couldn't load plugins directory")
-		return
+			"error":   err,
+			"guildId": guildID,
+		}).Warn("Couldn't get default sounds overrides from db")
+		defaults = service.DefaultSounds
 	}
-
-	for _, file := range files {
-		if !file.IsDir() && strings.Contains(file.Name(), ".so") {
-			p, err := plugin.Open(pluginsPath + string(os.PathSeparator) + file.Name())
-			if err != nil {
-				log.WithFields(log.Fields{
-					"error":  err,
-					"plugin": file.Name(),
-				}).Warn("Couldn't load plugin")
-				continue
-			}
-
-			name, err := p.Lookup("Name")
-			if err != nil {
-				log.WithFields(log.Fields{
-					"error":  err,
-					"plugin": file.Name(),
-				}).Error("Couldn't load Name from plugin")
-				continue
-			}
-
-			handleFunc, err := p.Lookup("Handle")
-			if err != nil {
-				log.WithFields(log.Fields{
-					"error":  err,
-					"plugin": name,
-				}).Warn("Couldn't load the Handle function from plugin")
-			}
-
-			getSoundFunc, err := p.Lookup("GetSound")
-			if err != nil {
-				log.WithFields(log.Fields{
-					"error":  err,
-					"plugin": name,
-				}).Warn("Couldn't load the GetSound function from plugin")
-			}
-
-			plug := &airhornPlugin{
-				name:     (*name.(*string)),
-				handle:   handleFunc.(func(string) bool),
-				getSound: getSoundFunc.(func(string) [][]byte),
-			}
-			plugins[plug.name] = plug
-			log.WithFields(log.Fields{
-				"plugin": file.Name(),
-			}).Info("Loaded plugin")
-		}
+	sounds := service.FilterByCommand(command, defaults)
+	guildSounds, err := service.GetSoundsByCommand(command, guildID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"guildId": guildID,
+		}).Warn("Couldn't get sounds from db")
 	}
+	sounds = append(sounds, guildSounds...)
+
+	// check plugins
+	return append(sounds, findPluginForSound(command, guildID)...)
 }
 
 func main() {
@@ -735,67 +972,104 @@ func main() {
 	if err != nil {
 		log.WithError(err).Fatal("Couldn't load configuration")
 	}
+	owner = cfg.DiscordOwnerID
 
-	loadPlugins(cfg.PluginPath)
-
-	if cfg.RedisHost != "" {
-		// connect to redis
-		log.Info("Connecting to redis...")
-		redisPool = &redis.Pool{
-			MaxIdle:     3,
-			IdleTimeout: 240 * time.Second,
-			Dial: func() (redis.Conn, error) {
-				return redis.Dial("tcp", cfg.RedisHost)
-			},
-		}
-		defer func() {
-			err := redisPool.Close()
-			if err != nil {
-				log.WithError(err).Error("Couldn't close redis pool connection")
-			}
-		}()
+	err = service.LoadLibrary(cfg.SoundsManifest)
+	if err != nil {
+		log.WithError(err).Fatal("Couldn't load the sounds library")
+	}
 
-		// test redis connection
-		conn := redisPool.Get()
-		_, err = conn.Do("PING")
-		if err != nil {
-			log.WithError(err).Fatal("Can't establish a connection to the redis server")
-			return
-		}
+	if runCommand(os.Args[1:]) {
+		return
+	}
+	if err = serve(true, false); err != nil {
+		log.WithError(err).Fatal("Couldn't run the bot")
+	}
+}
 
-		err = conn.Close()
-		if err != nil {
-			log.WithError(err).Error("Couldn't close redis connection")
-		}
+// initRedis connects to redis if it is configured, the pool is shared with
+// the web app when both run in this process
+func initRedis() {
+	if service.InitRedis(cfg) {
+		redisPool = service.RedisPool()
 	}
+}
 
+// startBot connects the bot to discord and answers the web app on b, its
+// background jobs run until ctx is done. It returns a function letting the
+// sounds playing end, until its context is done, and disconnecting the bot.
+// An error is sent on failed if the bot stops by itself.
+func startBot(ctx context.Context, b bus.Bus, failed chan<- error) (stop func(context.Context), err error) {
 	// Create a discord session
 	log.Info("Starting discord session...")
 	discord, err = discordgo.New(fmt.Sprintf("Bot %v", cfg.DiscordToken))
 	if err != nil {
-		log.WithError(err).Fatal("Failed to create discord session")
-		return
+		return nil, err
 	}
 
+	lease, err := joinShard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	discord.ShardID, discord.ShardCount = shard.ID, shard.Count
+	log.WithFields(log.Fields{
+		"shard": shard.ID,
+		"count": shard.Count,
+	}).Info("Running shard")
+
+	keepCtx, stopKeep := context.WithCancel(ctx)
+	if lease != nil {
+		go keepShard(keepCtx, lease, func(err error) {
+			select {
+			case failed <- err:
+			default:
+			}
+		})
+	}
+	// undoes the start of the bot when it fails
+	abort := func() {
+		stopKeep()
+		closePlugins()
+		if lease != nil {
+			lease.Release()
+		}
+	}
+
+	registerBuiltinPlugins()
+	loadPlugins(cfg.PluginPath)
+	go watchPlugins(cfg.PluginPath, ctx.Done())
+
 	discord.AddHandler(onReady)
 	discord.AddHandler(onMessageCreate)
+	discord.AddHandler(onVoiceStateUpdate)
+	discord.AddHandler(onGuildCreate)
+	discord.AddHandler(onMessageReactionAdd)
 
 	err = discord.Open()
 	if err != nil {
-		log.WithError(err).Fatal("Failed to create discord websocket connection")
-		return
+		abort()
+		return nil, err
+	}
+
+	go runSchedules(ctx.Done())
+
+	messageBus = b
+	if err = handleBus(messageBus); err != nil {
+		log.WithError(err).Error("Couldn't subscribe to the message bus")
 	}
 
 	// We're running!
 	log.Info("AIRHORNBOT is ready to horn it up.")
 
-	// Wait for a signal to quit
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
-	<-c
+	return func(ctx context.Context) {
+		drainSounds(ctx)
+		leaveAllVoice()
 
-	err = discord.Close()
-	if err != nil {
-		log.WithError(err).Error("Couldn't close discord session")
-	}
+		err := discord.Close()
+		if err != nil {
+			log.WithError(err).Error("Couldn't close discord session")
+		}
+		abort()
+		waitStats(ctx)
+	}, nil
 }
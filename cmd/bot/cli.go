@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+const usage = `Usage: airhornbot [command]
+
+Without command, runs the bot.
+
+Commands:
+  serve [-bot=false] [-web=false]      run the bot and the web dashboard in
+                                       this process, or only one of them
+  export -guild <id> [-o file.zip]     export the custom sounds of a guild
+  import -guild <id> [-conflict mode] file.zip
+                                       import sounds in a guild, mode is one
+                                       of rename (default), skip or overwrite
+  normalize -guild <id>                measure the loudness of the custom
+                                       sounds of a guild uploaded before it
+                                       was done on upload
+`
+
+// runCommand runs the command line subcommand in args, it returns false if
+// there is none and the bot should run
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "serve":
+		serveCommand(args[1:])
+	case "export":
+		exportCommand(args[1:])
+	case "import":
+		importCommand(args[1:])
+	case "normalize":
+		normalizeCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	return true
+}
+
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	guildID := fs.String("guild", "", "ID of the guild to export")
+	output := fs.String("o", "", "archive to write, defaults to <guild>.zip")
+	fs.Parse(args)
+
+	if *guildID == "" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = *guildID + ".zip"
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.WithError(err).Fatal("Couldn't create the archive")
+	}
+	defer f.Close()
+
+	err = service.ExportGuildSounds(*guildID, f)
+	if err != nil {
+		log.WithError(err).Fatal("Couldn't export the guild sounds")
+	}
+
+	log.WithFields(log.Fields{
+		"guild":   *guildID,
+		"archive": *output,
+	}).Info("Exported guild sounds")
+}
+
+func importCommand(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	guildID := fs.String("guild", "", "ID of the guild to import sounds in")
+	conflict := fs.String("conflict", string(service.ConflictRename),
+		"what to do with sounds named like an existing one: rename, skip or overwrite")
+	fs.Parse(args)
+
+	if *guildID == "" || fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	mode, err := service.ParseConflictMode(*conflict)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid conflict mode")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.WithError(err).Fatal("Couldn't open the archive")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		log.WithError(err).Fatal("Couldn't read the archive")
+	}
+
+	report, err := service.ImportGuildSounds(*guildID, f, info.Size(), mode)
+	if report != nil {
+		fmt.Printf("Imported: %s\n", strings.Join(report.Imported, ", "))
+		fmt.Printf("Renamed: %s\n", strings.Join(report.Renamed, ", "))
+		fmt.Printf("Overwritten: %s\n", strings.Join(report.Overwritten, ", "))
+		fmt.Printf("Skipped: %s\n", strings.Join(report.Skipped, ", "))
+		for _, e := range report.Errors {
+			fmt.Printf("Error: %s\n", e)
+		}
+	}
+	if err != nil {
+		log.WithError(err).Fatal("Couldn't import the sounds")
+	}
+}
+
+func normalizeCommand(args []string) {
+	fs := flag.NewFlagSet("normalize", flag.ExitOnError)
+	guildID := fs.String("guild", "", "ID of the guild to normalize")
+	fs.Parse(args)
+
+	if *guildID == "" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	sounds, err := service.GetSoundsByGuild(*guildID)
+	if err != nil {
+		log.WithError(err).Fatal("Couldn't get the guild sounds")
+	}
+
+	for _, s := range sounds {
+		s.GuildID = *guildID
+		loudness, err := service.MeasureGain(s)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"sound": s.Name,
+				"error": err,
+			}).Warn("Couldn't measure sound, skipping it")
+			continue
+		}
+
+		if err = s.SaveGain(); err != nil {
+			log.WithError(err).Fatal("Couldn't save the sound gain")
+		}
+		fmt.Printf("%s: %.1f dBFS, gain %+.1f dB\n", s.Name, loudness, s.Gain)
+	}
+}
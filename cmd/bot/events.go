@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"gitlab.com/Shywim/airhornbot/bus"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+// handleBus subscribes to the events of the web app and answers its queries
+func handleBus(b bus.Bus) error {
+	if err := bus.OnSoundChanged(b, onSoundChanged); err != nil {
+		return err
+	}
+	if err := bus.OnSettingsChanged(b, onSettingsChanged); err != nil {
+		return err
+	}
+	if err := bus.HandleNowPlaying(b, shard, nowPlaying); err != nil {
+		return err
+	}
+	if err := bus.HandleVoiceStatus(b, shard, voiceStatus); err != nil {
+		return err
+	}
+	return bus.HandlePlaySound(b, shard, playSoundQuery)
+}
+
+// onSoundChanged forgets the sounds with effects of the changed sounds, an
+// import may have replaced their audio
+func onSoundChanged(e *bus.SoundChanged) {
+	var sounds []*service.Sound
+	if e.SoundID != "" {
+		s, err := service.GetSound(e.SoundID)
+		if err != nil {
+			// deleted, its sounds are dropped from the cache in time
+			return
+		}
+		sounds = append(sounds, s)
+	} else {
+		var err error
+		sounds, err = service.GetSoundsByGuild(e.GuildID)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"guildId": e.GuildID,
+				"error":   err,
+			}).Warn("Couldn't get sounds from db")
+			return
+		}
+	}
+
+	for _, s := range sounds {
+		effectsCache.Remove(service.AudioPath(s))
+	}
+}
+
+// onSettingsChanged forgets the triggers of the guild, they are loaded again
+// on the next message
+func onSettingsChanged(e *bus.SettingsChanged) {
+	triggerCache.Delete(e.GuildID)
+}
+
+// nowPlaying answers what is playing in a guild, nil if the guild isn't
+// served by this bot
+func nowPlaying(q *bus.NowPlayingQuery) *bus.NowPlaying {
+	if _, err := discord.State.Guild(q.GuildID); err != nil {
+		return nil
+	}
+
+	r := &bus.NowPlaying{Queue: []string{}}
+	queue := getQueue(q.GuildID)
+	if queue == nil {
+		return r
+	}
+	current, plays := queue.list()
+	if current != nil {
+		r.Sound, r.UserID = current.Sound.Name, current.UserID
+	}
+	for _, p := range plays {
+		r.Queue = append(r.Queue, p.Sound.Name)
+	}
+	return r
+}
+
+// voiceStatus answers whether the bot is in a voice channel of a guild, nil
+// if the guild isn't served by this bot
+func voiceStatus(q *bus.VoiceStatusQuery) *bus.VoiceStatus {
+	if _, err := discord.State.Guild(q.GuildID); err != nil {
+		return nil
+	}
+
+	r := &bus.VoiceStatus{}
+	vc := currentVoice(q.GuildID)
+	if vc == nil {
+		return r
+	}
+	r.ChannelID = voiceChannelID(vc)
+	r.Connected = r.ChannelID != ""
+	if channel, err := discord.State.Channel(r.ChannelID); err == nil {
+		r.ChannelName = channel.Name
+	}
+	r.Listeners = countListeners(q.GuildID, r.ChannelID)
+	return r
+}
+
+// playSoundQuery plays a sound requested from the soundboard of the web app
+// in the voice channel of the user, the same way as a text command. It returns
+// nil if the guild isn't served by this bot.
+func playSoundQuery(q *bus.PlaySoundQuery) *bus.PlaySoundResult {
+	if _, err := discord.State.Guild(q.GuildID); err != nil {
+		return nil
+	}
+	if isDraining() {
+		return &bus.PlaySoundResult{Message: "The bot is restarting, try again in a moment"}
+	}
+
+	channelID := userVoiceChannelID(q.GuildID, q.UserID)
+	if channelID == "" {
+		return &bus.PlaySoundResult{Message: "Join a voice channel of this server first"}
+	}
+
+	u := &service.UserSound{GuildID: q.GuildID, SoundID: q.SoundID, DefaultName: q.DefaultName}
+	sound, err := u.Sound()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"guildId": q.GuildID,
+			"error":   err,
+		}).Warn("Couldn't get soundboard sound")
+		return &bus.PlaySoundResult{Message: "Couldn't find this sound"}
+	} else if sound == nil {
+		return &bus.PlaySoundResult{Message: "This sound doesn't exist anymore"}
+	}
+
+	if queue := getQueue(q.GuildID); queue != nil && queue.full() && !guildMixes(q.GuildID) {
+		return &bus.PlaySoundResult{Message: "Too many sounds are waiting, try again later"}
+	}
+
+	log.WithFields(log.Fields{
+		"guildId": q.GuildID,
+		"from":    q.UserID,
+		"sound":   sound.Name,
+	}).Info("Received soundboard request")
+
+	go queuePlay(&play{
+		GuildID:   q.GuildID,
+		ChannelID: channelID,
+		UserID:    q.UserID,
+		Sound:     sound,
+		Forced:    true,
+	})
+
+	name := channelID
+	if channel, err := discord.State.Channel(channelID); err == nil {
+		name = channel.Name
+	}
+	return &bus.PlaySoundResult{OK: true, Message: fmt.Sprintf("Playing %s in %s", sound.Name, name)}
+}
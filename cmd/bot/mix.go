@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+	"gitlab.com/Shywim/airhornbot/codec"
+	"gitlab.com/Shywim/airhornbot/codec/opus"
+	"gitlab.com/Shywim/airhornbot/pluginapi"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+var (
+	errMixerClosed = errors.New("the sounds are over")
+	errMixerFull   = errors.New("too many sounds are playing")
+)
+
+// mixer plays the sounds of a guild at once: they are decoded, summed and
+// encoded again in a single stream
+type mixer struct {
+	sync.Mutex
+
+	ctx    context.Context
+	vc     *discordgo.VoiceConnection
+	voices []*voice
+
+	// set once the last voice ended, sounds can't be added anymore
+	closed bool
+}
+
+// voice is a sound being mixed
+type voice struct {
+	stream  pluginapi.Stream
+	decoder *opus.Decoder
+
+	// decoded samples not mixed yet
+	pcm []int16
+
+	// frames read from the stream
+	frames int
+	done   bool
+}
+
+// guildMixes reports whether sounds are mixed in a guild
+func guildMixes(gID string) bool {
+	settings, err := service.GetGuildSettings(gID)
+	return err == nil && settings.Mix
+}
+
+// mixSounds plays a stream in vc and mixes the sounds added to the guild with
+// mixSound until they are all over or ctx is done
+func mixSounds(ctx context.Context, stream pluginapi.Stream, vc *discordgo.VoiceConnection) {
+	m := &mixer{ctx: ctx, vc: vc}
+	if err := m.addVoice(stream); err != nil {
+		log.WithError(err).Error("Error mixing sound")
+		stream.Close()
+		return
+	}
+
+	mixers.Store(vc.GuildID, m)
+	m.run()
+}
+
+// mixSound adds a play to the sounds mixed in its guild, it returns false if
+// the guild isn't mixing sounds in the channel of the play
+func mixSound(p *play) bool {
+	tmp, ok := mixers.Load(p.GuildID)
+	if !ok {
+		return false
+	}
+	m := tmp.(*mixer)
+
+	m.Lock()
+	closed := m.closed
+	m.Unlock()
+	if closed || voiceChannelID(m.vc) != p.ChannelID {
+		return false
+	}
+
+	stream, err := openSound(m.ctx, p)
+	if err != nil {
+		log.WithError(err).Error("Failed to read sound file")
+		return true
+	}
+
+	if err = m.addVoice(stream); err == errMixerClosed {
+		stream.Close()
+		return false
+	} else if err != nil {
+		log.WithFields(log.Fields{
+			"guildId": p.GuildID,
+			"sound":   p.Sound.Name,
+			"error":   err,
+		}).Info("Couldn't mix sound")
+		stream.Close()
+		return true
+	}
+
+	log.WithFields(log.Fields{
+		"p": p,
+	}).Info("Mixing sound")
+
+	trackStats(p)
+	if p.Sound.Gif != "" && p.TextChannelID != "" {
+		_, err = discord.ChannelMessageSend(p.TextChannelID, p.Sound.Gif)
+		if err != nil {
+			log.WithError(err).Warning("Failed to send gif to text channel")
+		}
+	}
+	return true
+}
+
+// addVoice adds a stream to the sounds being mixed
+func (m *mixer) addVoice(stream pluginapi.Stream) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.closed {
+		return errMixerClosed
+	}
+	if cfg.MaxVoices > 0 && len(m.voices) >= cfg.MaxVoices {
+		return errMixerFull
+	}
+
+	decoder, err := opus.NewDecoder()
+	if err != nil {
+		return err
+	}
+	m.voices = append(m.voices, &voice{stream: stream, decoder: decoder})
+	return nil
+}
+
+// run mixes a frame of every voice each 20ms and sends it, until every voice
+// is over or the context is done
+func (m *mixer) run() {
+	defer m.close()
+
+	encoder, err := opus.NewEncoder()
+	if err != nil {
+		log.WithError(err).Error("Error mixing sound")
+		return
+	}
+
+	_ = m.vc.Speaking(true)
+	defer func() {
+		err := m.vc.Speaking(false)
+		if err != nil {
+			log.WithError(err).Warning("Error while stopping speaking")
+		}
+	}()
+
+	ticker := time.NewTicker(frameDuration)
+	defer ticker.Stop()
+
+	out := make([]int16, opus.FrameSize*codec.Channels)
+	for {
+		// voices are only removed by this loop, the copy stays valid
+		m.Lock()
+		voices := append([]*voice(nil), m.voices...)
+		m.Unlock()
+
+		pcms := make([][]int16, 0, len(voices))
+		for _, v := range voices {
+			pcm, err := v.next()
+			if err != nil {
+				v.done = true
+				if err != io.EOF && m.ctx.Err() == nil {
+					log.WithError(err).Error("Error reading sound")
+				}
+			}
+			pcms = append(pcms, pcm)
+		}
+
+		codec.Mix(out, pcms)
+		frame, err := encoder.Encode(out)
+		if err != nil {
+			log.WithError(err).Error("Error mixing sound")
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.ctx.Done():
+			return
+		}
+		select {
+		case m.vc.OpusSend <- frame:
+		case <-m.ctx.Done():
+			return
+		}
+
+		if m.removeDone() == 0 {
+			return
+		}
+	}
+}
+
+// removeDone closes the voices which are over and returns how many are left
+func (m *mixer) removeDone() int {
+	m.Lock()
+	defer m.Unlock()
+
+	voices := m.voices[:0]
+	for _, v := range m.voices {
+		if !v.done {
+			voices = append(voices, v)
+		} else if err := v.stream.Close(); err != nil {
+			log.WithError(err).Warning("Error closing sound")
+		}
+	}
+	m.voices = voices
+
+	if len(m.voices) == 0 {
+		m.closed = true
+	}
+	return len(m.voices)
+}
+
+// close stops the mixer and closes the voices left
+func (m *mixer) close() {
+	m.Lock()
+	m.closed = true
+	for _, v := range m.voices {
+		if err := v.stream.Close(); err != nil {
+			log.WithError(err).Warning("Error closing sound")
+		}
+	}
+	m.voices = nil
+	m.Unlock()
+
+	if cur, ok := mixers.Load(m.vc.GuildID); ok && cur == m {
+		mixers.Delete(m.vc.GuildID)
+	}
+}
+
+// next returns the samples of the next 20ms of the voice, fewer at its end
+func (v *voice) next() ([]int16, error) {
+	size := opus.FrameSize * codec.Channels
+	maxFrames := int(cfg.MaxSoundDuration / frameDuration)
+
+	for len(v.pcm) < size {
+		if maxFrames > 0 && v.frames >= maxFrames {
+			log.WithFields(log.Fields{
+				"duration": cfg.MaxSoundDuration,
+			}).Info("Sound cut at the maximum duration")
+			return v.take(size), io.EOF
+		}
+
+		frame, err := v.stream.NextFrame()
+		if err != nil {
+			return v.take(size), err
+		}
+		v.frames++
+
+		pcm, err := v.decoder.Decode(frame)
+		if err != nil {
+			return v.take(size), err
+		}
+		v.pcm = append(v.pcm, pcm...)
+	}
+	return v.take(size), nil
+}
+
+// take removes up to n samples from the decoded ones
+func (v *voice) take(n int) []int16 {
+	if n > len(v.pcm) {
+		n = len(v.pcm)
+	}
+	pcm := v.pcm[:n]
+	v.pcm = v.pcm[n:]
+	return pcm
+}
@@ -0,0 +1,681 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gitlab.com/Shywim/airhornbot/builtin/say"
+	"gitlab.com/Shywim/airhornbot/pluginapi"
+	"gitlab.com/Shywim/airhornbot/pluginapi/rpcplugin"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+const (
+	// FilePath prefix of the sounds played by a plugin
+	pluginPathPrefix = "@plugin/"
+
+	// Source of the plugins shipped with the bot
+	builtinSource = "built-in"
+
+	// Time given to a plugin to start a sound
+	pluginSoundTimeout = 10 * time.Second
+)
+
+// Interval between two scans of the plugins directory
+const pluginsWatchInterval = 10 * time.Second
+
+var (
+	// Guards plugins and disabledPlugins
+	pluginsLock sync.RWMutex
+
+	// Serializes the scans of the plugins directory
+	pluginsScanLock sync.Mutex
+
+	// Plugins disabled by the owner in every guild
+	disabledPlugins = make(map[string]bool)
+
+	// Modification time of the plugin files which couldn't be loaded
+	rejectedPlugins = make(map[string]time.Time)
+)
+
+type airhornPlugin struct {
+	name     string
+	meta     pluginapi.Metadata
+	plugin   pluginapi.Plugin
+	commands []pluginapi.Command
+
+	// File of the plugins directory the plugin was loaded from
+	source  string
+	modTime time.Time
+
+	// Whether the plugin can be reloaded without restarting the bot
+	reloadable bool
+}
+
+// handles tells whether the plugin plays something for a command, a plugin
+// panicking is considered as not handling the command
+func (p *airhornPlugin) handles(command string) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithFields(log.Fields{
+				"plugin": p.name,
+				"panic":  r,
+			}).Error("Plugin panicked in Handles")
+			ok = false
+		}
+	}()
+
+	return p.plugin.Handles(command)
+}
+
+// declares tells whether a command is one of the commands the plugin listed
+// when it was loaded
+func (p *airhornPlugin) declares(command string) bool {
+	for _, c := range p.commands {
+		if strings.EqualFold(c.Name, command) {
+			return true
+		}
+	}
+	return false
+}
+
+// sound asks the plugin for a sound, a plugin panicking returns an error
+func (p *airhornPlugin) sound(ctx context.Context, req pluginapi.Request) (stream pluginapi.Stream, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin %s panicked: %v", p.name, r)
+		}
+	}()
+
+	stream, err = p.plugin.Sound(ctx, req)
+	if err == nil && stream == nil {
+		err = pluginapi.ErrNoSound
+	}
+	return stream, err
+}
+
+// pluginStream turns the panics of a plugin stream into errors
+type pluginStream struct {
+	plugin string
+	stream pluginapi.Stream
+}
+
+func (s *pluginStream) NextFrame() (frame []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin %s panicked: %v", s.plugin, r)
+		}
+	}()
+
+	return s.stream.NextFrame()
+}
+
+func (s *pluginStream) Close() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin %s panicked: %v", s.plugin, r)
+		}
+	}()
+
+	return s.stream.Close()
+}
+
+// openPluginSound asks the plugin of a play for its sound, the stream stops
+// once ctx is done
+func openPluginSound(ctx context.Context, p *play) (pluginapi.Stream, error) {
+	plug := getPlugin(strings.TrimPrefix(p.Sound.FilePath, pluginPathPrefix))
+	if plug == nil {
+		return nil, errors.New("Couldn't find a matching plugin for sound")
+	}
+
+	type result struct {
+		stream pluginapi.Stream
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		stream, err := plug.sound(ctx, pluginapi.Request{
+			Command: p.Sound.Name,
+			Args:    p.Args,
+			GuildID: p.GuildID,
+			UserID:  p.UserID,
+		})
+		done <- result{stream, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return &pluginStream{plugin: plug.name, stream: r.stream}, nil
+	case <-time.After(pluginSoundTimeout):
+		// release the sound if the plugin ever returns it
+		go func() {
+			if r := <-done; r.stream != nil {
+				r.stream.Close()
+			}
+		}()
+		return nil, fmt.Errorf("plugin %s took too long to start the sound", plug.name)
+	}
+}
+
+// findPluginForSound returns a sound for every plugin enabled in a guild which
+// plays something for a command. Only the plugins which declared the command
+// when they were loaded are asked, an out-of-process plugin not answering
+// doesn't hold the other commands.
+func findPluginForSound(name, guildID string) (sounds []*service.Sound) {
+	var declaring []*airhornPlugin
+	for _, p := range listPlugins() {
+		if !isPluginDisabled(p.name) && p.declares(name) {
+			declaring = append(declaring, p)
+		}
+	}
+	if len(declaring) == 0 {
+		return nil
+	}
+
+	disabled := guildDisabledPlugins(guildID)
+	for _, p := range declaring {
+		if disabled[p.name] || !p.handles(name) {
+			continue
+		}
+		sound := &service.Sound{
+			FilePath: pluginPathPrefix + p.name,
+			Name:     name,
+			Weight:   1,
+		}
+		sounds = append(sounds, sound)
+	}
+
+	return
+}
+
+// openPlugin opens a Go plugin and checks it implements the current version
+// of the plugin API
+func openPlugin(path string) (p pluginapi.Plugin, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin panicked: %v", r)
+		}
+	}()
+
+	so, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sym, err := so.Lookup(pluginapi.VersionSymbol)
+	if err != nil {
+		return nil, errors.New("no APIVersion exported, the plugin was probably built for the legacy plugin interface")
+	}
+	version, ok := sym.(*int)
+	if !ok {
+		return nil, fmt.Errorf("APIVersion is a %T, expected an int", sym)
+	}
+	if *version != pluginapi.Version {
+		return nil, fmt.Errorf("built for plugin API v%d, this bot supports v%d", *version, pluginapi.Version)
+	}
+
+	sym, err = so.Lookup(pluginapi.ConstructorSymbol)
+	if err != nil {
+		return nil, errors.New("no NewPlugin function exported")
+	}
+	newPlugin, ok := sym.(func() pluginapi.Plugin)
+	if !ok {
+		return nil, fmt.Errorf("NewPlugin is a %T, expected a func() pluginapi.Plugin", sym)
+	}
+
+	p = newPlugin()
+	if p == nil {
+		return nil, errors.New("NewPlugin returned nil")
+	}
+	return p, nil
+}
+
+// registerPlugin initializes a plugin and makes it available to the guilds,
+// source is the file it was loaded from or builtinSource
+func registerPlugin(p pluginapi.Plugin, source string, modTime time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin panicked: %v", r)
+		}
+	}()
+
+	meta := p.Metadata()
+	if meta.Name == "" {
+		return errors.New("the plugin has no name")
+	}
+	if getPlugin(meta.Name) != nil {
+		return fmt.Errorf("a plugin named %s is already loaded", meta.Name)
+	}
+
+	err = p.Init(pluginapi.Config{
+		DataPath: filepath.Join(cfg.DataPath, "plugins", meta.Name),
+		Settings: cfg.PluginSettings[meta.Name],
+	})
+	if err != nil {
+		return err
+	}
+
+	_, reloadable := p.(*rpcplugin.Client)
+	plug := &airhornPlugin{
+		name:       meta.Name,
+		meta:       meta,
+		plugin:     p,
+		commands:   p.Commands(),
+		source:     source,
+		modTime:    modTime,
+		reloadable: reloadable,
+	}
+
+	pluginsLock.Lock()
+	plugins[meta.Name] = plug
+	pluginsLock.Unlock()
+
+	log.WithFields(log.Fields{
+		"plugin":  meta.Name,
+		"version": meta.Version,
+		"source":  source,
+	}).Info("Loaded plugin")
+	return nil
+}
+
+// loadPlugin loads a plugin file, Go plugins end with .so and any other
+// executable file runs as an out-of-process plugin
+func loadPlugin(pluginsPath string, file os.FileInfo) error {
+	path := filepath.Join(pluginsPath, file.Name())
+
+	var (
+		p   pluginapi.Plugin
+		err error
+	)
+	if strings.HasSuffix(file.Name(), ".so") {
+		p, err = openPlugin(path)
+	} else {
+		p, err = startPlugin(path)
+	}
+	if err != nil {
+		return fmt.Errorf("rejected plugin: %v", err)
+	}
+
+	err = registerPlugin(p, file.Name(), file.ModTime())
+	if err != nil {
+		if c, ok := p.(io.Closer); ok {
+			c.Close()
+		}
+		return fmt.Errorf("couldn't initialize plugin: %v", err)
+	}
+	return nil
+}
+
+// startPlugin runs an executable plugin, it is restarted if it crashes or
+// stops answering
+func startPlugin(path string) (pluginapi.Plugin, error) {
+	return rpcplugin.Start(path)
+}
+
+// unloadPlugin removes a plugin and stops it if it runs in its own process.
+// A Go plugin stays in memory, it is only not used anymore.
+func unloadPlugin(p *airhornPlugin) {
+	pluginsLock.Lock()
+	if plugins[p.name] == p {
+		delete(plugins, p.name)
+	}
+	pluginsLock.Unlock()
+
+	if c, ok := p.plugin.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"plugin": p.name,
+			}).Warn("Error closing plugin")
+		}
+	}
+	log.WithField("plugin", p.name).Info("Unloaded plugin")
+}
+
+// isPluginFile tells whether a file of the plugins directory is a plugin
+func isPluginFile(file os.FileInfo) bool {
+	if !file.Mode().IsRegular() {
+		return false
+	}
+	return strings.HasSuffix(file.Name(), ".so") || file.Mode()&0111 != 0
+}
+
+// scanPlugins loads the new plugins of the plugins directory, reloads the
+// executable ones which changed and unloads the ones which were removed. It
+// returns whether the loaded plugins changed.
+func scanPlugins(pluginsPath string) (changed bool) {
+	pluginsScanLock.Lock()
+	defer pluginsScanLock.Unlock()
+
+	files, err := ioutil.ReadDir(pluginsPath)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Couldn't load plugins directory")
+		return false
+	}
+
+	loaded := make(map[string]*airhornPlugin)
+	for _, p := range listPlugins() {
+		if p.source != builtinSource {
+			loaded[p.source] = p
+		}
+	}
+
+	present := make(map[string]bool)
+	for _, file := range files {
+		if !isPluginFile(file) {
+			continue
+		}
+		present[file.Name()] = true
+
+		if p, ok := loaded[file.Name()]; ok {
+			if p.modTime.Equal(file.ModTime()) {
+				continue
+			}
+			if !p.reloadable {
+				log.WithField("plugin", p.name).Warn("Go plugin changed, restart the bot to load the new version")
+				p.modTime = file.ModTime()
+				continue
+			}
+			unloadPlugin(p)
+			changed = true
+		} else if t, ok := rejectedPlugins[file.Name()]; ok && t.Equal(file.ModTime()) {
+			// don't try again a plugin which didn't load until it changes
+			continue
+		}
+
+		if err = loadPlugin(pluginsPath, file); err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"plugin": file.Name(),
+			}).Error("Couldn't load plugin")
+			rejectedPlugins[file.Name()] = file.ModTime()
+			continue
+		}
+		delete(rejectedPlugins, file.Name())
+		changed = true
+	}
+
+	for source, p := range loaded {
+		if !present[source] {
+			unloadPlugin(p)
+			changed = true
+		}
+	}
+	for source := range rejectedPlugins {
+		if !present[source] {
+			delete(rejectedPlugins, source)
+		}
+	}
+
+	return changed
+}
+
+// registerBuiltinPlugins registers the plugins shipped with the bot
+func registerBuiltinPlugins() {
+	builtins := []pluginapi.Plugin{
+		say.New(filepath.Join(filepath.Dir(cfg.SoundsManifest), "say.toml")),
+	}
+
+	for _, p := range builtins {
+		if err := registerPlugin(p, builtinSource, time.Time{}); err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"plugin": p.Metadata().Name,
+			}).Error("Couldn't initialize built-in plugin")
+		}
+	}
+}
+
+func loadPlugins(pluginsPath string) {
+	scanPlugins(pluginsPath)
+	publishPlugins()
+}
+
+// watchPlugins looks for changes in the plugins directory until stop is closed
+func watchPlugins(pluginsPath string, stop <-chan struct{}) {
+	ticker := time.NewTicker(pluginsWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if scanPlugins(pluginsPath) {
+				publishPlugins()
+			}
+		}
+	}
+}
+
+// reloadPlugin restarts an out-of-process plugin from its file
+func reloadPlugin(name string) error {
+	pluginsScanLock.Lock()
+	defer pluginsScanLock.Unlock()
+
+	p := getPlugin(name)
+	if p == nil {
+		return fmt.Errorf("no plugin named %s", name)
+	}
+	if p.source == builtinSource {
+		return fmt.Errorf("%s is built in the bot", p.name)
+	}
+	if !p.reloadable {
+		return fmt.Errorf("%s is a Go plugin, restart the bot to reload it", p.name)
+	}
+
+	file, err := os.Stat(filepath.Join(cfg.PluginPath, p.source))
+	if err != nil {
+		return err
+	}
+
+	unloadPlugin(p)
+	err = loadPlugin(cfg.PluginPath, file)
+	publishPlugins()
+	return err
+}
+
+// setPluginDisabled disables or enables a plugin in every guild, until the
+// bot restarts
+func setPluginDisabled(name string, disabled bool) error {
+	p := getPlugin(name)
+	if p == nil {
+		return fmt.Errorf("no plugin named %s", name)
+	}
+
+	pluginsLock.Lock()
+	if disabled {
+		disabledPlugins[p.name] = true
+	} else {
+		delete(disabledPlugins, p.name)
+	}
+	pluginsLock.Unlock()
+	return nil
+}
+
+// getPlugin returns a loaded plugin by name, ignoring case since commands are
+// lowercased
+func getPlugin(name string) *airhornPlugin {
+	pluginsLock.RLock()
+	defer pluginsLock.RUnlock()
+
+	if p, ok := plugins[name]; ok {
+		return p
+	}
+	for n, p := range plugins {
+		if strings.EqualFold(n, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// listPlugins returns the loaded plugins sorted by name
+func listPlugins() []*airhornPlugin {
+	pluginsLock.RLock()
+	r := make([]*airhornPlugin, 0, len(plugins))
+	for _, p := range plugins {
+		r = append(r, p)
+	}
+	pluginsLock.RUnlock()
+
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].name < r[j].name
+	})
+	return r
+}
+
+// guildDisabledPlugins returns the names of the plugins disabled in a guild
+func guildDisabledPlugins(guildID string) map[string]bool {
+	disabled, err := service.GetDisabledPlugins(guildID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"guildId": guildID,
+		}).Warn("Couldn't get disabled plugins from db")
+	}
+	return disabled
+}
+
+// guildPlugins returns the plugins enabled in a guild
+func guildPlugins(guildID string) []*airhornPlugin {
+	disabled := guildDisabledPlugins(guildID)
+
+	var r []*airhornPlugin
+	for _, p := range listPlugins() {
+		if !disabled[p.name] && !isPluginDisabled(p.name) {
+			r = append(r, p)
+		}
+	}
+	return r
+}
+
+func isPluginDisabled(name string) bool {
+	pluginsLock.RLock()
+	defer pluginsLock.RUnlock()
+	return disabledPlugins[name]
+}
+
+// publishPlugins stores the list of loaded plugins in redis for the web app
+func publishPlugins() {
+	if redisPool == nil {
+		return
+	}
+
+	var infos []*service.PluginInfo
+	for _, p := range listPlugins() {
+		info := &service.PluginInfo{
+			Name:        p.name,
+			Version:     p.meta.Version,
+			Description: p.meta.Description,
+		}
+		for _, c := range p.commands {
+			info.Commands = append(info.Commands, c.Name)
+		}
+		infos = append(infos, info)
+	}
+
+	if err := service.PublishPlugins(redisPool, infos); err != nil {
+		log.WithError(err).Warn("Couldn't publish plugins list to redis")
+	}
+}
+
+// closePlugins releases the plugins implementing io.Closer
+func closePlugins() {
+	for _, p := range listPlugins() {
+		if c, ok := p.plugin.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.WithFields(log.Fields{
+					"error":  err,
+					"plugin": p.name,
+				}).Warn("Error closing plugin")
+			}
+		}
+	}
+}
+
+// Sends the loaded plugins and their status
+func displayPlugins(cid string) {
+	w := &tabwriter.Writer{}
+	buf := &bytes.Buffer{}
+
+	w.Init(buf, 0, 4, 1, ' ', 0)
+	fmt.Fprint(w, "```\n")
+	list := listPlugins()
+	for _, p := range list {
+		status := "enabled"
+		if isPluginDisabled(p.name) {
+			status = "disabled"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d commands\n", p.name, p.meta.Version, p.source, status, len(p.commands))
+	}
+	if len(list) == 0 {
+		fmt.Fprint(w, "No plugin loaded\n")
+	}
+	fmt.Fprint(w, "```\n")
+	if err := w.Flush(); err != nil {
+		log.WithError(err).Error("Error while building plugins message")
+		return
+	}
+
+	_, err := discord.ChannelMessageSend(cid, buf.String())
+	if err != nil {
+		log.WithError(err).Error("Error while sending plugins message")
+	}
+}
+
+// Handles the owner commands managing plugins: list, reload [name],
+// disable <name> and enable <name>
+func handlePluginsCommand(cid string, args []string) {
+	if len(args) == 0 || args[0] == "list" {
+		displayPlugins(cid)
+		return
+	}
+
+	var (
+		err error
+		msg string
+	)
+	switch {
+	case args[0] == "reload" && len(args) == 1:
+		loadPlugins(cfg.PluginPath)
+		msg = fmt.Sprintf("Plugins directory scanned, %d plugins loaded", len(listPlugins()))
+	case args[0] == "reload":
+		err = reloadPlugin(args[1])
+		msg = "Reloaded " + args[1]
+	case args[0] == "disable" && len(args) > 1:
+		err = setPluginDisabled(args[1], true)
+		msg = "Disabled " + args[1]
+	case args[0] == "enable" && len(args) > 1:
+		err = setPluginDisabled(args[1], false)
+		msg = "Enabled " + args[1]
+	default:
+		msg = "Usage: plugins list|reload [name]|disable <name>|enable <name>"
+	}
+	if err != nil {
+		msg = "Error: " + err.Error()
+	}
+
+	_, err = discord.ChannelMessageSend(cid, msg)
+	if err != nil {
+		log.WithError(err).Error("Error while sending plugins message")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+// guildQueue holds the sound playing in a guild and the plays waiting for it
+type guildQueue struct {
+	sync.Mutex
+	current *play
+	plays   []*play
+}
+
+func newGuildQueue() *guildQueue {
+	return &guildQueue{}
+}
+
+// getQueue returns the queue of a guild, nil if the bot isn't playing there
+func getQueue(gID string) *guildQueue {
+	tmp, ok := queues.Load(gID)
+	if !ok {
+		return nil
+	}
+	return tmp.(*guildQueue)
+}
+
+// push adds a play at the end of the queue, it returns false if the queue is
+// full
+func (q *guildQueue) push(p *play) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.plays) >= maxQueueSize {
+		return false
+	}
+	q.plays = append(q.plays, p)
+	return true
+}
+
+// full reports whether a play pushed now would be dropped
+func (q *guildQueue) full() bool {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.plays) >= maxQueueSize
+}
+
+// pop removes the first play of the queue, it returns nil if there is none
+func (q *guildQueue) pop() *play {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.plays) == 0 {
+		return nil
+	}
+	p := q.plays[0]
+	q.plays = q.plays[1:]
+	return p
+}
+
+// setCurrent sets the play of the sound playing, nil once it is over
+func (q *guildQueue) setCurrent(p *play) {
+	q.Lock()
+	q.current = p
+	q.Unlock()
+}
+
+// list returns the play of the sound playing and a copy of the queue
+func (q *guildQueue) list() (*play, []*play) {
+	q.Lock()
+	defer q.Unlock()
+	return q.current, append([]*play(nil), q.plays...)
+}
+
+// clear removes the plays requested by a user from the queue, or every play
+// if userID is empty. It returns how many were removed.
+func (q *guildQueue) clear(userID string) int {
+	q.Lock()
+	defer q.Unlock()
+
+	plays := q.plays[:0]
+	for _, p := range q.plays {
+		if userID != "" && p.UserID != userID {
+			plays = append(plays, p)
+		}
+	}
+	removed := len(q.plays) - len(plays)
+	q.plays = plays
+	return removed
+}
+
+// What a user can do with the sounds of a guild
+type control int
+
+const (
+	controlNone control = iota
+	// the sounds the user requested only
+	controlOwn
+	controlAll
+)
+
+// userControl returns what a user can do with the sounds of a guild, as set in
+// its settings. The owner of the bot and server admins control every sound.
+func userControl(guild *discordgo.Guild, cid, uid string) control {
+	if uid == owner {
+		return controlAll
+	}
+	perms, err := discord.State.UserChannelPermissions(uid, cid)
+	if err == nil && perms&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0 {
+		return controlAll
+	}
+
+	settings, err := service.GetGuildSettings(guild.ID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"guild": guild.ID,
+			"error": err,
+		}).Warn("Couldn't get the guild settings")
+		return controlNone
+	}
+
+	switch settings.Control {
+	case service.ControlRequester:
+		return controlOwn
+	case service.ControlRole:
+		if hasRole(guild, uid, settings.ControlRole) {
+			return controlAll
+		}
+		return controlNone
+	default:
+		return controlAll
+	}
+}
+
+// hasRole reports whether a member of a guild has a role, given by name or ID
+func hasRole(guild *discordgo.Guild, uid, role string) bool {
+	member, err := discord.State.Member(guild.ID, uid)
+	if err != nil {
+		member, err = discord.GuildMember(guild.ID, uid)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"guild": guild.ID,
+				"user":  uid,
+				"error": err,
+			}).Warn("Couldn't get guild member")
+			return false
+		}
+	}
+
+	for _, r := range guild.Roles {
+		if r.ID != role && !strings.EqualFold(r.Name, role) {
+			continue
+		}
+		for _, id := range member.Roles {
+			if id == r.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleQueueCommand runs the commands controlling the sounds of a guild, it
+// returns false if command isn't one of them
+func handleQueueCommand(m *discordgo.MessageCreate, guild *discordgo.Guild, command string) bool {
+	var msg string
+	switch command {
+	case "skip":
+		msg = skipCommand(m, guild)
+	case "stop":
+		msg = stopCommand(m, guild)
+	case "clear":
+		msg = clearCommand(m, guild)
+	case "queue":
+		msg = queueCommand(guild)
+	default:
+		return false
+	}
+
+	_, err := discord.ChannelMessageSend(m.ChannelID, msg)
+	if err != nil {
+		log.WithError(err).Error("Error sending queue message")
+	}
+	return true
+}
+
+const noControlMessage = "You can't control the sounds playing in this server"
+
+func skipCommand(m *discordgo.MessageCreate, guild *discordgo.Guild) string {
+	q := getQueue(guild.ID)
+	if q == nil {
+		return "Nothing is playing"
+	}
+	current, _ := q.list()
+	if current == nil {
+		return "Nothing is playing"
+	}
+
+	ctl := userControl(guild, m.ChannelID, m.Author.ID)
+	if ctl == controlNone || (ctl == controlOwn && current.UserID != m.Author.ID) {
+		return noControlMessage
+	}
+
+	if !skipSound(guild.ID) {
+		return "Nothing is playing"
+	}
+	return fmt.Sprintf("Skipped %s", current.Sound.Name)
+}
+
+func stopCommand(m *discordgo.MessageCreate, guild *discordgo.Guild) string {
+	q := getQueue(guild.ID)
+	if q == nil {
+		return "Nothing is playing"
+	}
+
+	ctl := userControl(guild, m.ChannelID, m.Author.ID)
+	if ctl == controlNone {
+		return noControlMessage
+	}
+
+	userID := ""
+	if ctl == controlOwn {
+		userID = m.Author.ID
+	}
+	removed := q.clear(userID)
+
+	current, _ := q.list()
+	if current != nil && (ctl == controlAll || current.UserID == m.Author.ID) && skipSound(guild.ID) {
+		return fmt.Sprintf("Stopped %s and removed %d sounds from the queue", current.Sound.Name, removed)
+	}
+	return fmt.Sprintf("Removed %d sounds from the queue", removed)
+}
+
+func clearCommand(m *discordgo.MessageCreate, guild *discordgo.Guild) string {
+	q := getQueue(guild.ID)
+	if q == nil {
+		return "The queue is empty"
+	}
+
+	ctl := userControl(guild, m.ChannelID, m.Author.ID)
+	if ctl == controlNone {
+		return noControlMessage
+	}
+
+	userID := ""
+	if ctl == controlOwn {
+		userID = m.Author.ID
+	}
+	return fmt.Sprintf("Removed %d sounds from the queue", q.clear(userID))
+}
+
+func queueCommand(guild *discordgo.Guild) string {
+	q := getQueue(guild.ID)
+	if q == nil {
+		return "Nothing is playing"
+	}
+	current, plays := q.list()
+	if current == nil && len(plays) == 0 {
+		return "Nothing is playing"
+	}
+
+	buf := &bytes.Buffer{}
+	if current != nil {
+		fmt.Fprintf(buf, "Playing %s, requested by %s\n", current.Sound.Name, memberName(guild.ID, current.UserID))
+	}
+	for i, p := range plays {
+		fmt.Fprintf(buf, "%d. %s, requested by %s\n", i+1, p.Sound.Name, memberName(guild.ID, p.UserID))
+	}
+	return buf.String()
+}
+
+// memberName returns the name of a member as displayed in a guild, without
+// mentioning them. Scheduled sounds have no member.
+func memberName(gID, uid string) string {
+	if uid == "" {
+		return "a schedule"
+	}
+	member, err := discord.State.Member(gID, uid)
+	if err != nil || member.User == nil {
+		return uid
+	}
+	if member.Nick != "" {
+		return member.Nick
+	}
+	return member.User.Username
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+	"gitlab.com/Shywim/airhornbot/schedule"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+// runSchedules plays the scheduled sounds of the guilds until stop is closed
+func runSchedules(stop <-chan struct{}) {
+	s := &schedule.Scheduler{
+		Clock: schedule.RealClock{},
+		Tick:  playSchedules,
+	}
+	s.Run(stop)
+}
+
+// playSchedules plays the sounds scheduled at a minute, one-shot schedules are
+// removed once due or missed
+func playSchedules(minute time.Time) {
+	schedules, err := service.GetAllSchedules()
+	if err != nil {
+		log.WithError(err).Warn("Couldn't get schedules from db")
+		return
+	}
+
+	for _, s := range schedules {
+		// the guilds of the other shards are played by their process
+		if !shard.Owns(s.GuildID) {
+			continue
+		}
+		if s.Expired(minute) {
+			// the bot was down when it was due, it is too late to play it
+			log.WithFields(log.Fields{
+				"guildId":  s.GuildID,
+				"schedule": s.ID,
+				"at":       s.At,
+			}).Info("Dropping missed one-shot schedule")
+			deleteOneShot(s)
+			continue
+		}
+		if !s.Due(minute) {
+			continue
+		}
+		if !s.Recurring() && !deleteOneShot(s) {
+			continue
+		}
+		playSchedule(s)
+	}
+}
+
+// deleteOneShot removes a one-shot schedule, it returns false if it couldn't
+func deleteOneShot(s *service.Schedule) bool {
+	if err := service.DeleteSchedule(s.GuildID, s.ID); err != nil {
+		log.WithFields(log.Fields{
+			"guildId":  s.GuildID,
+			"schedule": s.ID,
+			"error":    err,
+		}).Warn("Couldn't delete one-shot schedule")
+		return false
+	}
+	return true
+}
+
+// playSchedule plays the command of a schedule in its voice channel, unless
+// nobody is there to hear it
+func playSchedule(s *service.Schedule) {
+	guild, err := discord.State.Guild(s.GuildID)
+	if err != nil {
+		// the guild is handled by another instance or removed the bot
+		return
+	}
+
+	channelID := findVoiceChannel(guild, s.Channel)
+	if channelID == "" {
+		log.WithFields(log.Fields{
+			"guildId": s.GuildID,
+			"channel": s.Channel,
+		}).Info("Scheduled voice channel not found")
+		return
+	}
+	if countListeners(s.GuildID, channelID) == 0 {
+		log.WithFields(log.Fields{
+			"guildId":   s.GuildID,
+			"channelId": channelID,
+		}).Debug("Nobody in scheduled voice channel, skipping")
+		return
+	}
+
+	sounds := findSounds(s.Command, s.GuildID)
+	if len(sounds) == 0 {
+		log.WithFields(log.Fields{
+			"guildId": s.GuildID,
+			"sound":   s.Command,
+		}).Info("No sound found for scheduled command")
+		return
+	}
+
+	go queuePlay(&play{
+		GuildID:   s.GuildID,
+		ChannelID: channelID,
+		Sound:     random(sounds),
+	})
+}
+
+// findVoiceChannel returns the ID of a voice channel of a guild given by name
+// or ID, empty if there is none
+func findVoiceChannel(guild *discordgo.Guild, channel string) string {
+	discord.State.RLock()
+	defer discord.State.RUnlock()
+
+	for _, c := range guild.Channels {
+		if c.Type != discordgo.ChannelTypeGuildVoice {
+			continue
+		}
+		if c.ID == channel || strings.EqualFold(c.Name, channel) {
+			return c.ID
+		}
+	}
+	return ""
+}
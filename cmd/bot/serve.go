@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"gitlab.com/Shywim/airhornbot/bus"
+	"gitlab.com/Shywim/airhornbot/service"
+	"gitlab.com/Shywim/airhornbot/web"
+)
+
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	withBot := fs.Bool("bot", true, "run the bot")
+	withWeb := fs.Bool("web", true, "run the web dashboard")
+	fs.Parse(args)
+
+	if !*withBot && !*withWeb {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := serve(*withBot, *withWeb); err != nil {
+		log.WithError(err).Fatal("Couldn't serve")
+	}
+}
+
+// serve runs the bot, the dashboard or both with the same configuration,
+// database and redis pool until the process is interrupted or terminated
+func serve(withBot, withWeb bool) error {
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	initRedis()
+	if redisPool != nil {
+		defer service.CloseRedis()
+	}
+
+	// through redis the bus also reaches the bots and dashboards running in
+	// other processes, without it both sides of this one talk in memory
+	var b bus.Bus
+	if redisPool != nil {
+		b = bus.NewRedis(redisPool)
+	} else {
+		b = bus.NewLocal()
+	}
+	defer b.Close()
+
+	failed := make(chan error, 1)
+	var stopBot func(context.Context)
+	if withBot {
+		var err error
+		stopBot, err = startBot(ctx, b, failed)
+		if err != nil && ctx.Err() != nil {
+			// interrupted while waiting for a shard
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	var server *web.Server
+	if withWeb {
+		web.SetBus(b)
+		server = web.NewServer(cfg)
+		go func() {
+			err := server.ListenAndServe()
+			if err != http.ErrServerClosed {
+				failed <- err
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-failed:
+	}
+
+	// the dashboard stops first so it doesn't ask a stopping bot, the sounds
+	// playing then have the time left to end
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
+	if server != nil {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Error("Couldn't shut down the HTTP server")
+		}
+	}
+	if stopBot != nil {
+		stopBot(shutdownCtx)
+	}
+	return err
+}
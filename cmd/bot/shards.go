@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gitlab.com/Shywim/airhornbot/bus"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+// Time between two renewals of the lease of the shard, well within its TTL
+const shardRenewInterval = service.ShardLeaseTTL / 3
+
+// Time between two attempts to claim a shard while it is taken
+const shardRetryInterval = 5 * time.Second
+
+// Shard of the guilds served by this process
+var shard = bus.Shard{ID: 0, Count: 1}
+
+// joinShard picks the shard this process runs. With redis the shard count is
+// agreed on with the other processes and the shard is claimed, waiting for it
+// to be free until ctx is done; the lease is nil without redis.
+func joinShard(ctx context.Context) (*service.ShardLease, error) {
+	count, err := service.AgreeShardCount(redisPool, cfg.DiscordShardCount, recommendedShardCount)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DiscordShardID >= count {
+		return nil, fmt.Errorf("shard %d doesn't exist, there are %d shards", cfg.DiscordShardID, count)
+	}
+
+	if redisPool == nil {
+		id := cfg.DiscordShardID
+		if id < 0 {
+			if count > 1 {
+				return nil, fmt.Errorf("a shard ID is needed to run one of %d shards without redis", count)
+			}
+			id = 0
+		}
+		shard = bus.Shard{ID: id, Count: count}
+		return nil, nil
+	}
+
+	waiting := false
+	for {
+		lease, err := service.ClaimShard(redisPool, cfg.DiscordShardID, count)
+		if err == nil {
+			shard = bus.Shard{ID: lease.ID, Count: lease.Count}
+			return lease, nil
+		} else if err != service.ErrShardTaken {
+			return nil, err
+		}
+
+		if !waiting {
+			log.WithFields(log.Fields{
+				"shard": cfg.DiscordShardID,
+				"count": count,
+			}).Info("Shard taken by another process, waiting for it to be free")
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(shardRetryInterval):
+		}
+	}
+}
+
+// recommendedShardCount asks Discord how many shards the bot needs
+func recommendedShardCount() (int, error) {
+	gateway, err := discord.GatewayBot()
+	if err != nil {
+		return 0, err
+	}
+	if gateway.Shards < 1 {
+		return 1, nil
+	}
+	return gateway.Shards, nil
+}
+
+// keepShard renews the lease of the shard until ctx is done, lost is called
+// if it expired in the meantime
+func keepShard(ctx context.Context, lease *service.ShardLease, lost func(error)) {
+	ticker := time.NewTicker(shardRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := lease.Renew()
+		if err == service.ErrShardLost {
+			lost(err)
+			return
+		} else if err != nil {
+			log.WithError(err).Warn("Couldn't renew the lease of the shard")
+		}
+	}
+}
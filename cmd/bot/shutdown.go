@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Time between two checks of the sounds still playing on shutdown
+const drainInterval = 100 * time.Millisecond
+
+var (
+	// Set to 1 once the bot is shutting down, sounds requested then are
+	// dropped
+	draining int32
+
+	// Stats being written to redis, waited for on shutdown
+	statsWrites sync.WaitGroup
+)
+
+// signalContext returns a context cancelled when the process is interrupted
+// or terminated
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-c:
+			log.WithFields(log.Fields{
+				"signal": sig,
+			}).Info("Shutting down")
+		case <-ctx.Done():
+		}
+		signal.Stop(c)
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// isDraining reports whether the bot is shutting down
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// trackStats writes the stats of a play to redis in the background
+func trackStats(p *play) {
+	statsWrites.Add(1)
+	go func() {
+		defer statsWrites.Done()
+		trackSoundStats(p)
+	}()
+}
+
+// drainSounds drops the sounds waiting in the queues and waits for the ones
+// playing to end, they are cut once ctx is done
+func drainSounds(ctx context.Context) {
+	atomic.StoreInt32(&draining, 1)
+	queues.Range(func(_, q interface{}) bool {
+		q.(*guildQueue).clear("")
+		return true
+	})
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for isPlaying() {
+		select {
+		case <-ctx.Done():
+			log.Warn("Sounds still playing on shutdown, cutting them")
+			playbacks.Range(func(gID, _ interface{}) bool {
+				skipSound(gID.(string))
+				return true
+			})
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isPlaying reports whether a sound is playing in any guild
+func isPlaying() (playing bool) {
+	playbacks.Range(func(_, _ interface{}) bool {
+		playing = true
+		return false
+	})
+	return
+}
+
+// leaveAllVoice disconnects from the voice channels of every guild
+func leaveAllVoice() {
+	discord.RLock()
+	vcs := make(map[string]*discordgo.VoiceConnection, len(discord.VoiceConnections))
+	for gID, vc := range discord.VoiceConnections {
+		vcs[gID] = vc
+	}
+	discord.RUnlock()
+
+	for gID, vc := range vcs {
+		leaveVoice(gID, vc)
+	}
+}
+
+// waitStats waits for the stats being written to redis, at most until ctx is
+// done
+func waitStats(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		statsWrites.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn("Stats still being written on shutdown, they are lost")
+	}
+}
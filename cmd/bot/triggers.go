@@ -0,0 +1,179 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+// Time the triggers of a guild are kept in memory. Changes made on the
+// dashboard apply at once through the message bus, or after this long if an
+// event was missed.
+const triggersCacheTTL = time.Minute
+
+var (
+	// Map of Guild id's to their *guildTriggers
+	triggerCache = sync.Map{}
+
+	// Map of trigger id's to the time they last played a sound
+	triggerTimes     = make(map[string]time.Time)
+	triggerTimesLock sync.Mutex
+)
+
+// guildTriggers are the triggers of a guild, with their expressions compiled
+type guildTriggers struct {
+	loaded    time.Time
+	messages  []*messageTrigger
+	reactions []*service.Trigger
+}
+
+type messageTrigger struct {
+	*service.Trigger
+	re *regexp.Regexp
+}
+
+// getTriggers returns the triggers of a guild, from the cache if they were
+// loaded recently
+func getTriggers(gID string) *guildTriggers {
+	if tmp, ok := triggerCache.Load(gID); ok && time.Since(tmp.(*guildTriggers).loaded) < triggersCacheTTL {
+		return tmp.(*guildTriggers)
+	}
+
+	g := &guildTriggers{loaded: time.Now()}
+	triggers, err := service.GetTriggers(gID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"guildId": gID,
+			"error":   err,
+		}).Warn("Couldn't get triggers from db")
+	}
+	for _, t := range triggers {
+		if t.Kind == service.TriggerReaction {
+			g.reactions = append(g.reactions, t)
+			continue
+		}
+
+		re, err := t.Regexp()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"guildId": gID,
+				"trigger": t.ID,
+				"error":   err,
+			}).Warn("Invalid trigger expression")
+			continue
+		}
+		g.messages = append(g.messages, &messageTrigger{t, re})
+	}
+	triggerCache.Store(gID, g)
+	return g
+}
+
+// playMessageTriggers plays the sound of the first trigger a message matches,
+// a message plays one sound at most
+func playMessageTriggers(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author.Bot {
+		return
+	}
+	channel, err := s.State.Channel(m.ChannelID)
+	if err != nil || channel.GuildID == "" {
+		return
+	}
+
+	for _, t := range getTriggers(channel.GuildID).messages {
+		if t.re.MatchString(m.Content) && playTrigger(t.Trigger, m.Author.ID, m.ChannelID) {
+			return
+		}
+	}
+}
+
+func onMessageReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.UserID == s.State.User.ID {
+		return
+	}
+	channel, err := s.State.Channel(r.ChannelID)
+	if err != nil || channel.GuildID == "" {
+		return
+	}
+	if member, err := s.State.Member(channel.GuildID, r.UserID); err == nil && member.User != nil && member.User.Bot {
+		return
+	}
+
+	for _, t := range getTriggers(channel.GuildID).reactions {
+		if matchEmoji(t.Pattern, r.Emoji) && playTrigger(t, r.UserID, r.ChannelID) {
+			return
+		}
+	}
+}
+
+// matchEmoji reports whether an emoji is the one of a reaction trigger, given
+// as a unicode emoji or as the name or ID of a custom emoji
+func matchEmoji(pattern string, e discordgo.Emoji) bool {
+	// the same emoji may be sent with or without its variation selector
+	const variation = "\ufe0f"
+	if e.ID != "" && pattern == e.ID {
+		return true
+	}
+	return strings.Replace(pattern, variation, "", -1) == strings.Replace(e.Name, variation, "", -1)
+}
+
+// playTrigger plays the command of a trigger in the voice channel of the user
+// who set it off, the gif of the sound is sent in the text channel cid. It
+// returns false if the trigger is cooling down or nothing was played.
+func playTrigger(t *service.Trigger, uID, cid string) bool {
+	channelID := userVoiceChannelID(t.GuildID, uID)
+	if channelID == "" {
+		return false
+	}
+
+	sounds := findSounds(t.Command, t.GuildID)
+	if len(sounds) == 0 {
+		log.WithFields(log.Fields{
+			"guildId": t.GuildID,
+			"sound":   t.Command,
+		}).Info("No sound found for trigger")
+		return false
+	}
+
+	if !claimTrigger(t) {
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"guildId": t.GuildID,
+		"trigger": t.ID,
+		"from":    uID,
+	}).Info("Trigger matched")
+
+	go queuePlay(&play{
+		GuildID:       t.GuildID,
+		ChannelID:     channelID,
+		UserID:        uID,
+		Sound:         random(sounds),
+		TextChannelID: cid,
+	})
+	return true
+}
+
+// claimTrigger starts the cooldown of a trigger, it returns false if it is
+// already cooling down. The messages are handled concurrently, only one of a
+// burst of matching messages claims it.
+func claimTrigger(t *service.Trigger) bool {
+	cooldown := t.Cooldown
+	if cooldown < service.MinTriggerCooldown {
+		// saved before the minimum existed
+		cooldown = service.MinTriggerCooldown
+	}
+
+	triggerTimesLock.Lock()
+	defer triggerTimesLock.Unlock()
+	if last, ok := triggerTimes[t.ID]; ok && time.Since(last) < cooldown {
+		return false
+	}
+	triggerTimes[t.ID] = time.Now()
+	return true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+)
+
+func onVoiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	if vc := currentVoice(v.GuildID); vc != nil {
+		followVoiceState(s, v, vc)
+	}
+	playWalkOn(v)
+}
+
+// followVoiceState follows the voice channel of the bot: it leaves when it is
+// alone or disconnected, and keeps playing when moved
+func followVoiceState(s *discordgo.Session, v *discordgo.VoiceStateUpdate, vc *discordgo.VoiceConnection) {
+	if v.UserID == s.State.User.ID {
+		if v.ChannelID == "" {
+			log.WithFields(log.Fields{
+				"guildId": v.GuildID,
+			}).Info("Disconnected from voice channel")
+			leaveVoice(v.GuildID, vc)
+			return
+		}
+
+		// joined, moved or muted, discordgo already updated the channel of
+		// the connection
+		log.WithFields(log.Fields{
+			"guildId":   v.GuildID,
+			"channelId": v.ChannelID,
+		}).Debug("Voice state of the bot changed")
+	}
+
+	channelID := voiceChannelID(vc)
+	if channelID != "" && countListeners(v.GuildID, channelID) == 0 && !movingAway(v.GuildID, channelID) {
+		log.WithFields(log.Fields{
+			"guildId":   v.GuildID,
+			"channelId": channelID,
+		}).Info("Alone in voice channel, leaving")
+		leaveVoice(v.GuildID, vc)
+	}
+}
+
+// movingAway reports whether the sound starting in a guild is played in
+// another channel than channelID, the bot is about to move there
+func movingAway(gID, channelID string) bool {
+	queuesLock.Lock()
+	defer queuesLock.Unlock()
+
+	queue := getQueue(gID)
+	if queue == nil {
+		return false
+	}
+	current, _ := queue.list()
+	return current != nil && current.ChannelID != channelID
+}
+
+// currentVoice returns the voice connection of the bot in a guild, nil if
+// there is none
+func currentVoice(gID string) *discordgo.VoiceConnection {
+	discord.RLock()
+	defer discord.RUnlock()
+	return discord.VoiceConnections[gID]
+}
+
+// voiceChannelID returns the channel of a voice connection, it changes when
+// the bot is moved
+func voiceChannelID(vc *discordgo.VoiceConnection) string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.ChannelID
+}
+
+// userVoiceChannelID returns the voice channel a user is in, empty if they
+// aren't in one
+func userVoiceChannelID(gID, uID string) string {
+	guild, err := discord.State.Guild(gID)
+	if err != nil {
+		return ""
+	}
+
+	discord.State.RLock()
+	defer discord.State.RUnlock()
+	for _, vs := range guild.VoiceStates {
+		if vs.UserID == uID {
+			return vs.ChannelID
+		}
+	}
+	return ""
+}
+
+// countListeners returns how many users, bots aside, are in a voice channel
+func countListeners(gID, cID string) int {
+	guild, err := discord.State.Guild(gID)
+	if err != nil {
+		return 0
+	}
+
+	discord.State.RLock()
+	var users []string
+	for _, vs := range guild.VoiceStates {
+		if vs.ChannelID == cID && vs.UserID != discord.State.User.ID {
+			users = append(users, vs.UserID)
+		}
+	}
+	discord.State.RUnlock()
+
+	count := 0
+	for _, uID := range users {
+		member, err := discord.State.Member(gID, uID)
+		if err == nil && member.User != nil && member.User.Bot {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// leaveVoice stops the sound playing in a guild, forgets its queue and
+// disconnects from its voice channel
+func leaveVoice(gID string, vc *discordgo.VoiceConnection) {
+	queuesLock.Lock()
+	queues.Delete(gID)
+	stopDisconnect(gID)
+	queuesLock.Unlock()
+
+	skipSound(gID)
+	voiceDisconnect(vc)
+}
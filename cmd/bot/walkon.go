@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bwmarrin/discordgo"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+var (
+	// Map of guild and user id's to the voice channel the user is in, to tell
+	// joins and leaves from the other voice state changes
+	userChannels = sync.Map{}
+
+	// Map of guild and user id's to the time their last sound was played when
+	// they joined or left a channel
+	walkOnTimes = sync.Map{}
+)
+
+func userKey(gID, uID string) string {
+	return gID + ":" + uID
+}
+
+// onGuildCreate records the voice channels users are in when the bot starts
+func onGuildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
+	for _, vs := range g.VoiceStates {
+		userChannels.Store(userKey(g.ID, vs.UserID), vs.ChannelID)
+	}
+}
+
+// playWalkOn plays the sound a user chose for joining or leaving a voice
+// channel. Moving to another channel counts as joining it.
+func playWalkOn(v *discordgo.VoiceStateUpdate) {
+	key := userKey(v.GuildID, v.UserID)
+	previous := ""
+	if tmp, ok := userChannels.Load(key); ok {
+		previous = tmp.(string)
+	}
+	if v.ChannelID == "" {
+		userChannels.Delete(key)
+	} else {
+		userChannels.Store(key, v.ChannelID)
+	}
+
+	// muted, deafened...
+	if v.ChannelID == previous || v.UserID == discord.State.User.ID {
+		return
+	}
+
+	event, channelID := service.UserSoundJoin, v.ChannelID
+	if channelID == "" {
+		event, channelID = service.UserSoundLeave, previous
+	}
+
+	if t, ok := walkOnTimes.Load(key); ok && time.Since(t.(time.Time)) < cfg.WalkOnCooldown {
+		return
+	}
+	if member, err := discord.State.Member(v.GuildID, v.UserID); err == nil && member.User != nil && member.User.Bot {
+		return
+	}
+
+	settings, err := service.GetGuildSettings(v.GuildID)
+	if err != nil || !settings.WalkOn {
+		return
+	}
+
+	u, err := service.GetUserSound(v.GuildID, v.UserID, event)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"guildId": v.GuildID,
+			"userId":  v.UserID,
+			"error":   err,
+		}).Warn("Couldn't get user sound")
+		return
+	} else if u == nil {
+		return
+	}
+	sound, err := u.Sound()
+	if err != nil || sound == nil {
+		return
+	}
+
+	// nobody would hear it
+	if event == service.UserSoundLeave && countListeners(v.GuildID, channelID) == 0 {
+		return
+	}
+
+	walkOnTimes.Store(key, time.Now())
+	queuePlay(&play{
+		GuildID:   v.GuildID,
+		ChannelID: channelID,
+		UserID:    v.UserID,
+		Sound:     sound,
+	})
+}
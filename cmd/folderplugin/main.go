@@ -0,0 +1,134 @@
+// Command folderplugin is a sample out-of-process plugin: every .dca file of a
+// folder becomes a command named after the file.
+//
+// Build it and drop the binary in the plugins directory of the bot, the folder
+// is set in the configuration of the bot:
+//
+//	[plugin.folder]
+//	path = "/etc/airhornbot/folder"
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jonas747/dca"
+	"gitlab.com/Shywim/airhornbot/pluginapi"
+	"gitlab.com/Shywim/airhornbot/pluginapi/rpcplugin"
+)
+
+type folderPlugin struct {
+	mu     sync.RWMutex
+	path   string
+	sounds map[string]string
+}
+
+func (p *folderPlugin) Metadata() pluginapi.Metadata {
+	return pluginapi.Metadata{
+		Name:        "folder",
+		Version:     "1.0.0",
+		Description: "Plays the sounds of a folder",
+		Author:      "airhornbot",
+	}
+}
+
+func (p *folderPlugin) Init(cfg pluginapi.Config) error {
+	path, _ := cfg.Settings["path"].(string)
+	if path == "" {
+		path = filepath.Join(cfg.DataPath, "sounds")
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	sounds := make(map[string]string)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".dca") {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(f.Name(), ".dca"))
+		sounds[name] = filepath.Join(path, f.Name())
+	}
+
+	p.mu.Lock()
+	p.path = path
+	p.sounds = sounds
+	p.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"path":   path,
+		"sounds": len(sounds),
+	}).Info("Loaded folder")
+	return nil
+}
+
+func (p *folderPlugin) Commands() (commands []pluginapi.Command) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for name := range p.sounds {
+		commands = append(commands, pluginapi.Command{
+			Name:        name,
+			Description: "Plays " + name + " from " + p.path,
+		})
+	}
+	return commands
+}
+
+func (p *folderPlugin) Handles(command string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.sounds[command]
+	return ok
+}
+
+func (p *folderPlugin) Sound(ctx context.Context, req pluginapi.Request) (pluginapi.Stream, error) {
+	p.mu.RLock()
+	path, ok := p.sounds[req.Command]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, pluginapi.ErrUnknownCommand
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &dcaStream{file: file, decoder: dca.NewDecoder(file)}, nil
+}
+
+// dcaStream reads the opus frames of a dca file
+type dcaStream struct {
+	file    *os.File
+	decoder *dca.Decoder
+}
+
+func (s *dcaStream) NextFrame() ([]byte, error) {
+	frame, err := s.decoder.OpusFrame()
+	if err == io.ErrUnexpectedEOF {
+		return nil, io.EOF
+	}
+	return frame, err
+}
+
+func (s *dcaStream) Close() error {
+	return s.file.Close()
+}
+
+func main() {
+	// stdout carries the protocol, logs go to stderr and are forwarded by the bot
+	log.SetOutput(os.Stderr)
+
+	if err := rpcplugin.Serve(&folderPlugin{}); err != nil {
+		log.WithError(err).Fatal("Couldn't serve plugin")
+	}
+}
@@ -0,0 +1,249 @@
+// Package codec describes the effects applied to the sounds and applies them
+// to PCM samples. Package codec/opus decodes and encodes the opus frames.
+package codec
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Format of the samples: interleaved stereo at 48kHz, like Discord expects
+const (
+	SampleRate = 48000
+	Channels   = 2
+)
+
+// Bounds of the effects settings
+const (
+	MinGain  = -20.0
+	MaxGain  = 20.0
+	MinSpeed = 0.5
+	MaxSpeed = 2.0
+)
+
+// Gain and speed set by the shorthand modifiers
+const (
+	loudGain  = 10.0
+	quietGain = -10.0
+	fastSpeed = 1.5
+	slowSpeed = 0.75
+)
+
+const (
+	// Delay and volume of the echo
+	echoDelay = 250 // ms
+	echoDecay = 0.4
+
+	// Prefix of the effects given as command modifiers
+	modifierPrefix = "--"
+)
+
+// Effects are applied to a sound before it is played
+type Effects struct {
+	// Gain in dB, 0 keeps the volume
+	Gain float64
+
+	// Speed factor, the pitch changes too. 0 or 1 keep the speed.
+	Speed float64
+
+	Reverse bool
+	Echo    bool
+}
+
+// IsZero reports whether the effects leave a sound untouched
+func (e Effects) IsZero() bool {
+	return e.Gain == 0 && (e.Speed == 0 || e.Speed == 1) && !e.Reverse && !e.Echo
+}
+
+// String returns the effects in the format read by ParseEffects, the same
+// effects always give the same string
+func (e Effects) String() string {
+	var parts []string
+	if e.Gain != 0 {
+		parts = append(parts, "gain="+strconv.FormatFloat(e.Gain, 'g', -1, 64))
+	}
+	if e.Speed != 0 && e.Speed != 1 {
+		parts = append(parts, "speed="+strconv.FormatFloat(e.Speed, 'g', -1, 64))
+	}
+	if e.Reverse {
+		parts = append(parts, "reverse")
+	}
+	if e.Echo {
+		parts = append(parts, "echo")
+	}
+	return strings.Join(parts, ",")
+}
+
+// Merge returns the effects with the ones set in o replacing them
+func (e Effects) Merge(o Effects) Effects {
+	if o.Gain != 0 {
+		e.Gain = o.Gain
+	}
+	if o.Speed != 0 {
+		e.Speed = o.Speed
+	}
+	e.Reverse = e.Reverse || o.Reverse
+	e.Echo = e.Echo || o.Echo
+	return e
+}
+
+// ParseEffects reads a comma separated list of effects, e.g.
+// "reverse, gain=6, speed=1.5". The shorthands loud, quiet, fast and slow are
+// accepted too.
+func ParseEffects(s string) (Effects, error) {
+	e := Effects{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		if err := e.set(part); err != nil {
+			return Effects{}, err
+		}
+	}
+	return e, nil
+}
+
+// ParseModifiers takes the effects out of the words following a command, e.g.
+// "--reverse" or "--speed=1.5". Words which are not modifiers are returned
+// in rest.
+func ParseModifiers(args []string) (e Effects, rest []string) {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, modifierPrefix) {
+			rest = append(rest, arg)
+			continue
+		}
+		if err := e.set(strings.ToLower(strings.TrimPrefix(arg, modifierPrefix))); err != nil {
+			rest = append(rest, arg)
+		}
+	}
+	return e, rest
+}
+
+// set sets a single effect
+func (e *Effects) set(s string) error {
+	name, value := s, ""
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		name, value = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	}
+
+	switch name {
+	case "reverse":
+		e.Reverse = true
+	case "echo":
+		e.Echo = true
+	case "loud":
+		e.Gain = loudGain
+	case "quiet":
+		e.Gain = quietGain
+	case "fast":
+		e.Speed = fastSpeed
+	case "slow":
+		e.Speed = slowSpeed
+	case "gain":
+		gain, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(gain) || gain < MinGain || gain > MaxGain {
+			return fmt.Errorf("the gain must be a number between %g and %g", MinGain, MaxGain)
+		}
+		e.Gain = gain
+	case "speed":
+		speed, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(speed) || speed < MinSpeed || speed > MaxSpeed {
+			return fmt.Errorf("the speed must be a number between %g and %g", MinSpeed, MaxSpeed)
+		}
+		e.Speed = speed
+	default:
+		return fmt.Errorf("unknown effect: %s", name)
+	}
+	return nil
+}
+
+// Apply applies the effects to interleaved stereo samples
+func (e Effects) Apply(pcm []int16) []int16 {
+	if e.Speed != 0 && e.Speed != 1 {
+		pcm = changeSpeed(pcm, e.Speed)
+	}
+	if e.Reverse {
+		pcm = reverse(pcm)
+	}
+	if e.Echo {
+		pcm = echo(pcm)
+	}
+	if e.Gain != 0 {
+		pcm = gain(pcm, e.Gain)
+	}
+	return pcm
+}
+
+// clip converts a sample back to 16 bits, saturating it
+func clip(v float64) int16 {
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	} else if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
+func gain(pcm []int16, db float64) []int16 {
+	factor := math.Pow(10, db/20)
+	out := make([]int16, len(pcm))
+	for i, s := range pcm {
+		out[i] = clip(float64(s) * factor)
+	}
+	return out
+}
+
+func reverse(pcm []int16) []int16 {
+	n := len(pcm) / Channels
+	out := make([]int16, n*Channels)
+	for i := 0; i < n; i++ {
+		copy(out[i*Channels:(i+1)*Channels], pcm[(n-1-i)*Channels:(n-i)*Channels])
+	}
+	return out
+}
+
+// changeSpeed resamples the sound with a linear interpolation
+func changeSpeed(pcm []int16, speed float64) []int16 {
+	n := len(pcm) / Channels
+	outN := int(float64(n) / speed)
+	if n == 0 || outN == 0 {
+		return nil
+	}
+
+	out := make([]int16, outN*Channels)
+	for i := 0; i < outN; i++ {
+		pos := float64(i) * speed
+		j := int(pos)
+		frac := pos - float64(j)
+		for c := 0; c < Channels; c++ {
+			a := float64(pcm[j*Channels+c])
+			b := a
+			if j+1 < n {
+				b = float64(pcm[(j+1)*Channels+c])
+			}
+			out[i*Channels+c] = clip(a + (b-a)*frac)
+		}
+	}
+	return out
+}
+
+// echo adds a delayed and quieter copy of the sound, the sound is lengthened
+// by the delay so the last echo is heard
+func echo(pcm []int16) []int16 {
+	delay := SampleRate * echoDelay / 1000 * Channels
+	out := make([]int16, len(pcm)+delay)
+	for i := range out {
+		v := 0.0
+		if i < len(pcm) {
+			v = float64(pcm[i])
+		}
+		if i >= delay {
+			v += float64(pcm[i-delay]) * echoDecay
+		}
+		out[i] = clip(v)
+	}
+	return out
+}
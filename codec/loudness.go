@@ -0,0 +1,76 @@
+package codec
+
+import (
+	"math"
+)
+
+const (
+	// Loudness of the stock airhorn sound, in dBFS. Sounds are normalized to
+	// it.
+	ReferenceLoudness = -14.3
+
+	// Most a quiet sound is amplified by the normalization, in dB, to not
+	// amplify noise too much
+	maxNormalizationBoost = 6.0
+
+	// Samples quieter than this are silence, they don't count in the
+	// loudness (dBFS)
+	silenceThreshold = -60.0
+)
+
+// Loudness returns the RMS level of samples in dBFS, ignoring silent 20ms
+// blocks. It returns -Inf for silence.
+func Loudness(pcm []int16) float64 {
+	block := SampleRate / 50 * Channels
+	threshold := math.Pow(10, silenceThreshold/20) * math.MaxInt16
+
+	var (
+		sum   float64
+		count int
+	)
+	for i := 0; i < len(pcm); i += block {
+		end := i + block
+		if end > len(pcm) {
+			end = len(pcm)
+		}
+
+		var blockSum float64
+		for _, s := range pcm[i:end] {
+			blockSum += float64(s) * float64(s)
+		}
+		if math.Sqrt(blockSum/float64(end-i)) < threshold {
+			continue
+		}
+		sum += blockSum
+		count += end - i
+	}
+
+	if count == 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(math.Sqrt(sum/float64(count))/math.MaxInt16)
+}
+
+// NormalizationGain returns the gain in dB bringing a sound of the given
+// loudness to the reference loudness, rounded to a tenth of dB
+func NormalizationGain(loudness float64) float64 {
+	if math.IsInf(loudness, 0) || math.IsNaN(loudness) {
+		return 0
+	}
+
+	gain := ReferenceLoudness - loudness
+	if gain > maxNormalizationBoost {
+		gain = maxNormalizationBoost
+	} else if gain < MinGain {
+		gain = MinGain
+	}
+	return math.Floor(gain*10+0.5) / 10
+}
+
+// VolumeGain returns the gain in dB of a volume in percent
+func VolumeGain(volume int) float64 {
+	if volume <= 0 {
+		return MinGain
+	}
+	return 20 * math.Log10(float64(volume)/100)
+}
@@ -0,0 +1,36 @@
+package codec
+
+import (
+	"math"
+)
+
+// Level from which the sum of the mixed sounds is compressed instead of
+// clipped, relative to the full scale
+const mixKnee = 0.75
+
+// Mix sums the samples of several sounds into out, voices shorter than out
+// count as silence. The sum is smoothly limited when it gets too loud instead
+// of being clipped.
+func Mix(out []int16, voices [][]int16) {
+	for i := range out {
+		var sum float64
+		for _, v := range voices {
+			if i < len(v) {
+				sum += float64(v[i])
+			}
+		}
+		out[i] = clip(limit(sum))
+	}
+}
+
+// limit compresses a sample above the knee so it never reaches the full scale
+func limit(v float64) float64 {
+	knee := mixKnee * math.MaxInt16
+	a := math.Abs(v)
+	if a <= knee {
+		return v
+	}
+
+	room := math.MaxInt16 - knee
+	return math.Copysign(knee+room*math.Tanh((a-knee)/room), v)
+}
@@ -0,0 +1,190 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Opus frames put in an Ogg page, about a second of sound
+const oggPageFrames = 50
+
+// Most segments of an Ogg page
+const oggMaxSegments = 255
+
+// Flags of an Ogg page header
+const (
+	oggContinued = 0x01
+	oggFirstPage = 0x02
+	oggLastPage  = 0x04
+)
+
+var errInvalidFrame = errors.New("invalid opus frame")
+
+// OggWriter writes opus frames in an Ogg container (RFC 7845), the frames are
+// copied as they are so browsers can play them
+type OggWriter struct {
+	w       io.Writer
+	serial  uint32
+	seq     uint32
+	granule uint64
+
+	frames   [][]byte
+	segments int
+}
+
+// NewOggWriter writes the headers of an Ogg Opus stream to w. serial
+// identifies the stream, gain in dB is applied by the player.
+func NewOggWriter(w io.Writer, serial uint32, gain float64) (*OggWriter, error) {
+	o := &OggWriter{w: w, serial: serial}
+
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1
+	head[9] = Channels
+	// pre-skip is unknown, the first few milliseconds are played too
+	binary.LittleEndian.PutUint16(head[10:], 0)
+	binary.LittleEndian.PutUint32(head[12:], SampleRate)
+	binary.LittleEndian.PutUint16(head[16:], uint16(int16(math.Floor(gain*256+0.5))))
+	head[18] = 0
+	if err := o.writePage(oggFirstPage, 0, [][]byte{head}); err != nil {
+		return nil, err
+	}
+
+	vendor := "airhornbot"
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags, "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+	if err := o.writePage(0, 0, [][]byte{tags}); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// WriteFrame adds an opus frame to the stream
+func (o *OggWriter) WriteFrame(frame []byte) error {
+	samples := frameSamples(frame)
+	if samples == 0 {
+		return errInvalidFrame
+	}
+
+	segments := len(frame)/255 + 1
+	if segments > oggMaxSegments {
+		return errInvalidFrame
+	}
+	if len(o.frames) >= oggPageFrames || o.segments+segments > oggMaxSegments {
+		if err := o.flush(0); err != nil {
+			return err
+		}
+	}
+
+	o.frames = append(o.frames, frame)
+	o.segments += segments
+	o.granule += uint64(samples)
+	return nil
+}
+
+// Close writes the last page of the stream, it doesn't close the writer
+func (o *OggWriter) Close() error {
+	return o.flush(oggLastPage)
+}
+
+func (o *OggWriter) flush(flags byte) error {
+	err := o.writePage(flags, o.granule, o.frames)
+	o.frames = o.frames[:0]
+	o.segments = 0
+	return err
+}
+
+// writePage writes an Ogg page of packets ending at granule
+func (o *OggWriter) writePage(flags byte, granule uint64, packets [][]byte) error {
+	var lacing []byte
+	size := 0
+	for _, p := range packets {
+		for n := len(p); ; n -= 255 {
+			if n < 255 {
+				lacing = append(lacing, byte(n))
+				break
+			}
+			lacing = append(lacing, 255)
+		}
+		size += len(p)
+	}
+
+	page := make([]byte, 27+len(lacing), 27+len(lacing)+size)
+	copy(page, "OggS")
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], o.serial)
+	binary.LittleEndian.PutUint32(page[18:], o.seq)
+	page[26] = byte(len(lacing))
+	copy(page[27:], lacing)
+	for _, p := range packets {
+		page = append(page, p...)
+	}
+	binary.LittleEndian.PutUint32(page[22:], oggChecksum(page))
+
+	o.seq++
+	_, err := o.w.Write(page)
+	return err
+}
+
+// frameSamples returns the number of samples per channel of an opus frame,
+// read from its TOC byte (RFC 6716 section 3.1), 0 if it is invalid
+func frameSamples(frame []byte) int {
+	if len(frame) == 0 {
+		return 0
+	}
+
+	config := int(frame[0] >> 3)
+	var size int
+	switch {
+	case config < 12:
+		// SILK: 10, 20, 40, 60ms
+		size = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		// Hybrid: 10, 20ms
+		size = []int{480, 960}[config%2]
+	default:
+		// CELT: 2.5, 5, 10, 20ms
+		size = []int{120, 240, 480, 960}[config%4]
+	}
+
+	count := 1
+	switch frame[0] & 3 {
+	case 1, 2:
+		count = 2
+	case 3:
+		if len(frame) < 2 {
+			return 0
+		}
+		count = int(frame[1] & 0x3f)
+	}
+	return size * count
+}
+
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// oggChecksum computes the CRC of an Ogg page, its checksum field being 0
+func oggChecksum(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
@@ -0,0 +1,100 @@
+package opus
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"gitlab.com/Shywim/airhornbot/codec"
+)
+
+// Cache keeps the most recently used processed sounds in memory
+type Cache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cacheEntry struct {
+	key    string
+	frames [][]byte
+}
+
+// NewCache returns a cache holding at most max sounds
+func NewCache(max int) *Cache {
+	return &Cache{
+		max:     max,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get returns the frames cached for key
+func (c *Cache) Get(key string) ([][]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry).frames, true
+}
+
+// Add caches frames for key, the least recently used sound is dropped if the
+// cache is full
+func (c *Cache) Add(key string, frames [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*cacheEntry).frames = frames
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, frames: frames})
+	for c.order.Len() > c.max {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.entries, el.Value.(*cacheEntry).key)
+	}
+}
+
+// Remove drops every processed version of the sound identified by key
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := key + "|"
+	for k, el := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			c.order.Remove(el)
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Process returns the frames of a sound with effects applied, from the cache
+// if they were already processed. key identifies the sound, read is called
+// to get its frames when they are not cached.
+func (c *Cache) Process(key string, e codec.Effects, read func() ([][]byte, error)) ([][]byte, error) {
+	key += "|" + e.String()
+	if frames, ok := c.Get(key); ok {
+		return frames, nil
+	}
+
+	frames, err := read()
+	if err != nil {
+		return nil, err
+	}
+	frames, err = Process(frames, e)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Add(key, frames)
+	return frames, nil
+}
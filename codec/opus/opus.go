@@ -0,0 +1,167 @@
+// Package opus decodes and encodes the opus frames played by the bot, to
+// apply effects to them. It needs cgo.
+package opus
+
+import (
+	"gitlab.com/Shywim/airhornbot/codec"
+	"layeh.com/gopus"
+)
+
+const (
+	// Samples per channel in a 20ms frame
+	FrameSize = 960
+
+	// Bitrate of the encoded frames, in bits/s
+	Bitrate = 128000
+
+	maxFrameBytes = 4000
+
+	// Samples per channel in the longest opus frame (120ms)
+	maxDecodedSize = 5760
+)
+
+// Decode decodes opus frames to interleaved stereo samples
+func Decode(frames [][]byte) ([]int16, error) {
+	decoder, err := gopus.NewDecoder(codec.SampleRate, codec.Channels)
+	if err != nil {
+		return nil, err
+	}
+
+	pcm := make([]int16, 0, len(frames)*FrameSize*codec.Channels)
+	for _, frame := range frames {
+		samples, err := decoder.Decode(frame, maxDecodedSize, false)
+		if err != nil {
+			return nil, err
+		}
+		pcm = append(pcm, samples...)
+	}
+	return pcm, nil
+}
+
+// Encode encodes interleaved stereo samples to 20ms opus frames, the last
+// frame is padded with silence
+func Encode(pcm []int16) ([][]byte, error) {
+	encoder, err := gopus.NewEncoder(codec.SampleRate, codec.Channels, gopus.Audio)
+	if err != nil {
+		return nil, err
+	}
+	encoder.SetBitrate(Bitrate)
+
+	samplesPerFrame := FrameSize * codec.Channels
+	var frames [][]byte
+	for i := 0; i < len(pcm); i += samplesPerFrame {
+		chunk := pcm[i:]
+		if len(chunk) < samplesPerFrame {
+			chunk = make([]int16, samplesPerFrame)
+			copy(chunk, pcm[i:])
+		} else {
+			chunk = chunk[:samplesPerFrame]
+		}
+
+		frame, err := encoder.Encode(chunk, FrameSize, maxFrameBytes)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// Process applies effects to opus frames
+func Process(frames [][]byte, e codec.Effects) ([][]byte, error) {
+	if e.IsZero() {
+		return frames, nil
+	}
+
+	pcm, err := Decode(frames)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(e.Apply(pcm))
+}
+
+// Decoder decodes the frames of a stream one by one, it keeps the state of the
+// codec between frames
+type Decoder struct {
+	decoder *gopus.Decoder
+}
+
+// NewDecoder returns a decoder for a new stream
+func NewDecoder() (*Decoder, error) {
+	decoder, err := gopus.NewDecoder(codec.SampleRate, codec.Channels)
+	if err != nil {
+		return nil, err
+	}
+	return &Decoder{decoder: decoder}, nil
+}
+
+// Decode decodes a frame to interleaved stereo samples
+func (d *Decoder) Decode(frame []byte) ([]int16, error) {
+	return d.decoder.Decode(frame, maxDecodedSize, false)
+}
+
+// Encoder encodes the 20ms frames of a stream one by one
+type Encoder struct {
+	encoder *gopus.Encoder
+}
+
+// NewEncoder returns an encoder for a new stream
+func NewEncoder() (*Encoder, error) {
+	encoder, err := gopus.NewEncoder(codec.SampleRate, codec.Channels, gopus.Audio)
+	if err != nil {
+		return nil, err
+	}
+	encoder.SetBitrate(Bitrate)
+	return &Encoder{encoder: encoder}, nil
+}
+
+// Encode encodes FrameSize interleaved stereo samples
+func (e *Encoder) Encode(pcm []int16) ([]byte, error) {
+	return e.encoder.Encode(pcm, FrameSize, maxFrameBytes)
+}
+
+// Loudness decodes opus frames and returns their loudness, see codec.Loudness
+func Loudness(frames [][]byte) (float64, error) {
+	pcm, err := Decode(frames)
+	if err != nil {
+		return 0, err
+	}
+	return codec.Loudness(pcm), nil
+}
+
+// Gainer changes the volume of opus frames one by one, for streams too long
+// to be processed at once. It keeps the state of the codec between frames.
+type Gainer struct {
+	decoder *gopus.Decoder
+	encoder *gopus.Encoder
+	effects codec.Effects
+}
+
+// NewGainer returns a Gainer applying a gain in dB
+func NewGainer(gain float64) (*Gainer, error) {
+	decoder, err := gopus.NewDecoder(codec.SampleRate, codec.Channels)
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := gopus.NewEncoder(codec.SampleRate, codec.Channels, gopus.Audio)
+	if err != nil {
+		return nil, err
+	}
+	encoder.SetBitrate(Bitrate)
+
+	return &Gainer{
+		decoder: decoder,
+		encoder: encoder,
+		effects: codec.Effects{Gain: gain},
+	}, nil
+}
+
+// Frame returns a frame with the gain applied
+func (g *Gainer) Frame(frame []byte) ([]byte, error) {
+	pcm, err := g.decoder.Decode(frame, maxDecodedSize, false)
+	if err != nil {
+		return nil, err
+	}
+	pcm = g.effects.Apply(pcm)
+	return g.encoder.Encode(pcm, len(pcm)/codec.Channels, maxFrameBytes)
+}
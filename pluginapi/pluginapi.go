@@ -0,0 +1,125 @@
+// Package pluginapi defines the interface of airhorn plugins.
+//
+// A plugin is a Go plugin (built with -buildmode=plugin) exporting two
+// symbols:
+//
+//	var APIVersion = pluginapi.Version
+//	func NewPlugin() pluginapi.Plugin { ... }
+//
+// The bot rejects plugins built for another version of this package.
+package pluginapi
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Version of the plugin API, bumped on every incompatible change
+const Version = 1
+
+// Names of the symbols looked up in a plugin
+const (
+	VersionSymbol     = "APIVersion"
+	ConstructorSymbol = "NewPlugin"
+)
+
+var (
+	// ErrUnknownCommand is returned by Sound for a command the plugin does
+	// not handle
+	ErrUnknownCommand = errors.New("pluginapi: unknown command")
+
+	// ErrNoSound is returned by Sound when the plugin has nothing to play
+	ErrNoSound = errors.New("pluginapi: no sound to play")
+)
+
+// Metadata describes a plugin
+type Metadata struct {
+	// Name identifies the plugin, it must be unique
+	Name        string
+	Version     string
+	Description string
+	Author      string
+}
+
+// Config is given to a plugin when it is initialized
+type Config struct {
+	// Directory where the plugin can store its data
+	DataPath string
+
+	// Settings of the plugin, from the [plugin.<name>] section of the
+	// bot configuration
+	Settings map[string]interface{}
+}
+
+// Command is a command handled by a plugin, listed in the bot help
+type Command struct {
+	// Name of the command, without the "!"
+	Name        string
+	Description string
+}
+
+// Request is a command typed by a user
+type Request struct {
+	// Command without the "!"
+	Command string
+	// Words following the command
+	Args    []string
+	GuildID string
+	UserID  string
+}
+
+// Stream is a sound played by a plugin
+type Stream interface {
+	// NextFrame returns the next opus frame (48kHz, stereo, 20ms), or
+	// io.EOF once the sound is over
+	NextFrame() ([]byte, error)
+
+	// Close releases the stream, it is called even if the sound is not
+	// played until the end
+	Close() error
+}
+
+// Plugin is implemented by airhorn plugins
+type Plugin interface {
+	Metadata() Metadata
+
+	// Init is called once, before any other method but Metadata
+	Init(cfg Config) error
+
+	// Commands lists the commands of the plugin for the help, it is called
+	// once the plugin is initialized
+	Commands() []Command
+
+	// Handles tells whether the plugin plays something for a command, it is
+	// only called for the commands listed by Commands
+	Handles(command string) bool
+
+	// Sound returns the sound to play for a request. The stream must stop
+	// once ctx is done.
+	Sound(ctx context.Context, req Request) (Stream, error)
+}
+
+// FramesStream returns a Stream playing opus frames held in memory
+func FramesStream(frames [][]byte) Stream {
+	return &framesStream{frames: frames}
+}
+
+type framesStream struct {
+	frames [][]byte
+}
+
+func (s *framesStream) NextFrame() ([]byte, error) {
+	if len(s.frames) == 0 {
+		return nil, io.EOF
+	}
+
+	frame := s.frames[0]
+	s.frames = s.frames[1:]
+	return frame, nil
+}
+
+func (s *framesStream) Close() error {
+	s.frames = nil
+	return nil
+}
@@ -0,0 +1,436 @@
+package rpcplugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gitlab.com/Shywim/airhornbot/pluginapi"
+)
+
+const (
+	// Time given to a plugin to answer a call
+	callTimeout = 5 * time.Second
+
+	// Interval between health checks of a plugin
+	healthInterval = 30 * time.Second
+
+	// A plugin restarted more than maxRestarts times in restartWindow is
+	// considered broken and is not restarted anymore
+	maxRestarts   = 5
+	restartWindow = 10 * time.Minute
+
+	// Number of frames fetched by a Frames call (1 second)
+	framesBatch = 50
+
+	// Longest line of a plugin output logged as one message
+	maxLogLine = 64 * 1024
+)
+
+var (
+	// ErrTimeout is returned when a plugin does not answer in time
+	ErrTimeout = errors.New("rpcplugin: plugin did not answer in time")
+
+	// ErrStopped is returned by the calls made after the plugin was stopped
+	ErrStopped = errors.New("rpcplugin: plugin is stopped")
+)
+
+// Client runs a plugin process and implements pluginapi.Plugin by calling it.
+// The process is restarted if it exits or stops answering.
+type Client struct {
+	path string
+	meta pluginapi.Metadata
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	rpc      *rpc.Client
+	cfg      *pluginapi.Config
+	restarts []time.Time
+	stopped  bool
+
+	done chan struct{}
+}
+
+// Start runs the plugin executable at path and checks it implements the
+// current version of the plugin API
+func Start(path string) (*Client, error) {
+	c := &Client{
+		path: path,
+		done: make(chan struct{}),
+	}
+
+	cmd, client, hello, err := c.spawn(nil)
+	if err != nil {
+		return nil, err
+	}
+	if hello.APIVersion != pluginapi.Version {
+		stopProcess(cmd, client)
+		return nil, fmt.Errorf("built for plugin API v%d, this bot supports v%d",
+			hello.APIVersion, pluginapi.Version)
+	}
+	c.meta = hello.Metadata
+
+	c.cmd = cmd
+	c.rpc = client
+	go c.wait(cmd)
+	go c.healthLoop()
+	return c, nil
+}
+
+// spawn runs the plugin process, says hello and gives it cfg if not nil. It
+// doesn't touch the state of c, restart calls it with c.mu held.
+func (c *Client) spawn(cfg *pluginapi.Config) (*exec.Cmd, *rpc.Client, *HelloReply, error) {
+	cmd := exec.Command(c.path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// Wait returns once the output is logged, with the last lines of a
+	// plugin crashing
+	cmd.Stderr = &logWriter{plugin: c.path}
+
+	if err = cmd.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	client := rpc.NewClientWithCodec(jsonrpc.NewClientCodec(&pipe{stdout, stdin}))
+	hello := &HelloReply{}
+	err = callWithTimeout(client, "Hello", Empty{}, hello)
+	if err == nil && cfg != nil {
+		err = callWithTimeout(client, "Init", *cfg, &Empty{})
+	}
+	if err != nil {
+		stopProcess(cmd, client)
+		return nil, nil, nil, err
+	}
+
+	return cmd, client, hello, nil
+}
+
+// stopProcess kills a plugin process which was never in use
+func stopProcess(cmd *exec.Cmd, client *rpc.Client) {
+	client.Close()
+	cmd.Process.Kill()
+	waitProcess(cmd)
+}
+
+// waitProcess waits for a plugin process to exit and logs the end of its
+// output
+func waitProcess(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	cmd.Stderr.(*logWriter).flush()
+	return err
+}
+
+// wait restarts the plugin if its process exits while in use
+func (c *Client) wait(cmd *exec.Cmd) {
+	err := waitProcess(cmd)
+
+	c.mu.Lock()
+	current := c.cmd == cmd && !c.stopped
+	c.mu.Unlock()
+	if !current {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"plugin": c.meta.Name,
+		"error":  err,
+	}).Warn("Plugin exited, restarting it")
+	if err = c.restart(cmd); err != nil {
+		log.WithFields(log.Fields{
+			"plugin": c.meta.Name,
+			"error":  err,
+		}).Error("Couldn't restart plugin")
+	}
+}
+
+// logWriter logs what a plugin writes on its standard error, line by line
+type logWriter struct {
+	plugin string
+	buf    []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > maxLogLine {
+		w.flush()
+	}
+	return len(p), nil
+}
+
+// flush logs the line being written
+func (w *logWriter) flush() {
+	if len(w.buf) > 0 {
+		w.log(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *logWriter) log(line []byte) {
+	log.WithFields(log.Fields{
+		"plugin": w.plugin,
+	}).Info(string(bytes.TrimRight(line, "\r")))
+}
+
+// healthLoop pings the plugin and restarts it when it doesn't answer
+func (c *Client) healthLoop() {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		cmd, client, err := c.current()
+		if err == ErrStopped {
+			return
+		} else if err == nil {
+			err = callWithTimeout(client, "Ping", Empty{}, &Empty{})
+		}
+		if err == nil {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"plugin": c.meta.Name,
+			"error":  err,
+		}).Warn("Plugin health check failed, restarting it")
+
+		if err := c.restart(cmd); err != nil {
+			log.WithFields(log.Fields{
+				"plugin": c.meta.Name,
+				"error":  err,
+			}).Error("Couldn't restart plugin")
+		}
+	}
+}
+
+// restart replaces the failed plugin process from by a new one, initialized
+// with the configuration given to Init. Restarts run one at a time with c.mu
+// held, so Close waits for them; a restart is dropped if from was already
+// replaced.
+func (c *Client) restart(from *exec.Cmd) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return ErrStopped
+	}
+	if c.cmd != from {
+		return nil
+	}
+
+	now := time.Now()
+	recent := c.restarts[:0]
+	for _, t := range c.restarts {
+		if now.Sub(t) < restartWindow {
+			recent = append(recent, t)
+		}
+	}
+	c.restarts = append(recent, now)
+	if len(c.restarts) > maxRestarts {
+		return errors.New("rpcplugin: plugin restarted too many times")
+	}
+
+	c.kill()
+	cmd, client, _, err := c.spawn(c.cfg)
+	if err != nil {
+		return err
+	}
+	c.cmd = cmd
+	c.rpc = client
+	go c.wait(cmd)
+
+	return nil
+}
+
+// kill stops the plugin process, c.mu must be held
+func (c *Client) kill() {
+	if c.rpc != nil {
+		c.rpc.Close()
+		c.rpc = nil
+	}
+	if c.cmd != nil && c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+		c.cmd = nil
+	}
+}
+
+// current returns the running plugin process and its client
+func (c *Client) current() (*exec.Cmd, *rpc.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return nil, nil, ErrStopped
+	}
+	if c.rpc == nil {
+		return c.cmd, nil, errors.New("rpcplugin: plugin is not running")
+	}
+	return c.cmd, c.rpc, nil
+}
+
+// call calls a method of the plugin, with a timeout
+func (c *Client) call(method string, args interface{}, reply interface{}) error {
+	_, client, err := c.current()
+	if err != nil {
+		return err
+	}
+	return callWithTimeout(client, method, args, reply)
+}
+
+func callWithTimeout(client *rpc.Client, method string, args interface{}, reply interface{}) error {
+	call := client.Go(serviceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+
+	timer := time.NewTimer(callTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-timer.C:
+		return ErrTimeout
+	}
+}
+
+// Metadata implements pluginapi.Plugin
+func (c *Client) Metadata() pluginapi.Metadata {
+	return c.meta
+}
+
+// Init implements pluginapi.Plugin, the configuration is given again to the
+// plugin when it is restarted
+func (c *Client) Init(cfg pluginapi.Config) error {
+	c.mu.Lock()
+	c.cfg = &cfg
+	c.mu.Unlock()
+
+	return c.call("Init", cfg, &Empty{})
+}
+
+// Commands implements pluginapi.Plugin
+func (c *Client) Commands() []pluginapi.Command {
+	var commands []pluginapi.Command
+	if err := c.call("Commands", Empty{}, &commands); err != nil {
+		log.WithFields(log.Fields{
+			"plugin": c.meta.Name,
+			"error":  err,
+		}).Warn("Couldn't list plugin commands")
+	}
+	return commands
+}
+
+// Handles implements pluginapi.Plugin, a plugin not answering doesn't handle
+// any command
+func (c *Client) Handles(command string) bool {
+	var ok bool
+	if err := c.call("Handles", command, &ok); err != nil {
+		return false
+	}
+	return ok
+}
+
+// Sound implements pluginapi.Plugin
+func (c *Client) Sound(ctx context.Context, req pluginapi.Request) (pluginapi.Stream, error) {
+	reply := &OpenReply{}
+	if err := c.call("Open", req, reply); err != nil {
+		return nil, err
+	}
+
+	return &clientStream{
+		client: c,
+		ctx:    ctx,
+		id:     reply.StreamID,
+	}, nil
+}
+
+// Close stops the plugin process
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.stopped {
+		c.stopped = true
+		close(c.done)
+		c.kill()
+	}
+	return nil
+}
+
+// clientStream fetches the frames of a plugin stream by batches
+type clientStream struct {
+	client *Client
+	ctx    context.Context
+	id     uint64
+	frames [][]byte
+	eof    bool
+}
+
+func (s *clientStream) NextFrame() ([]byte, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(s.frames) == 0 {
+		if s.eof {
+			return nil, io.EOF
+		}
+
+		reply := &FramesReply{}
+		err := s.client.call("Frames", FramesArgs{StreamID: s.id, Max: framesBatch}, reply)
+		if err != nil {
+			return nil, err
+		}
+		s.frames = reply.Frames
+		s.eof = reply.EOF
+
+		if len(s.frames) == 0 {
+			return nil, io.EOF
+		}
+	}
+
+	frame := s.frames[0]
+	s.frames = s.frames[1:]
+	return frame, nil
+}
+
+func (s *clientStream) Close() error {
+	return s.client.call("Close", s.id, &Empty{})
+}
+
+// pipe joins the output and input of the plugin process into a connection
+type pipe struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (p *pipe) Close() error {
+	err := p.WriteCloser.Close()
+	if rerr := p.ReadCloser.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
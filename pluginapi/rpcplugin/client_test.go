@@ -0,0 +1,238 @@
+package rpcplugin
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gitlab.com/Shywim/airhornbot/pluginapi"
+)
+
+// startFakePlugin builds testdata/fakeplugin and starts it, cleanup closes
+// it and removes the binary
+func startFakePlugin(t *testing.T) (c *Client, cleanup func()) {
+	dir, err := ioutil.TempDir("", "rpcplugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "fakeplugin")
+	out, err := exec.Command("go", "build", "-o", bin, "./testdata/fakeplugin").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("couldn't build the fake plugin: %v\n%s", err, out)
+	}
+
+	c, err = Start(bin)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// process returns the plugin process running
+func process(c *Client) *exec.Cmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cmd
+}
+
+// waitExit waits for a process to be killed and reaped
+func waitExit(t *testing.T, cmd *exec.Cmd) {
+	deadline := time.Now().Add(5 * time.Second)
+	for cmd.Process.Signal(syscall.Signal(0)) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("process %d still running", cmd.Process.Pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClient(t *testing.T) {
+	c, cleanup := startFakePlugin(t)
+	defer cleanup()
+
+	if meta := c.Metadata(); meta.Name != "fake" || meta.Version != "1.0.0" {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+	if c.Handles("configured") {
+		t.Fatal("plugin configured before Init")
+	}
+	if err := c.Init(pluginapi.Config{DataPath: "data"}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Handles("beep") || c.Handles("nope") || !c.Handles("configured") {
+		t.Fatal("wrong commands handled")
+	}
+
+	first := c.Commands()
+	if len(first) != 1 {
+		t.Fatalf("unexpected commands %v", first)
+	}
+
+	s, err := c.Sound(context.Background(), pluginapi.Request{Command: "beep"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frames []byte
+	for {
+		frame, err := s.NextFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame...)
+	}
+	s.Close()
+	if string(frames) != "\x01\x02\x03" {
+		t.Fatalf("unexpected frames %v", frames)
+	}
+
+	// the plugin exits, a new process configured again replaces it
+	crashed := process(c)
+	c.Handles("crash")
+	waitExit(t, crashed)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		commands := c.Commands()
+		if len(commands) == 1 && commands[0].Name != first[0].Name {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("plugin not restarted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !c.Handles("configured") {
+		t.Fatal("plugin not configured after its restart")
+	}
+
+	running := process(c)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitExit(t, running)
+	if c.Handles("beep") {
+		t.Fatal("closed plugin handles commands")
+	}
+	if _, err := c.Sound(context.Background(), pluginapi.Request{Command: "beep"}); err != ErrStopped {
+		t.Fatalf("expected ErrStopped, got %v", err)
+	}
+}
+
+// outputHook records the lines logged from the output of plugins
+type outputHook struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (h *outputHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *outputHook) Fire(e *log.Entry) error {
+	if _, ok := e.Data["plugin"]; ok && e.Level == log.InfoLevel {
+		h.mu.Lock()
+		h.lines = append(h.lines, e.Message)
+		h.mu.Unlock()
+	}
+	return nil
+}
+
+func (h *outputHook) logged(line string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, l := range h.lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCrashOutput(t *testing.T) {
+	hook := &outputHook{}
+	log.AddHook(hook)
+
+	c, cleanup := startFakePlugin(t)
+	defer cleanup()
+
+	// the last line isn't ended, it is logged when the process exits
+	crashed := process(c)
+	c.Handles("crash")
+	waitExit(t, crashed)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !hook.logged("crashing") || !hook.logged("last words") {
+		if time.Now().After(deadline) {
+			t.Fatal("output of the plugin not logged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRestartOnce(t *testing.T) {
+	c, cleanup := startFakePlugin(t)
+	defer cleanup()
+
+	// the exit of the process and the health check both notice the failure
+	failed := process(c)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.restart(failed); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	waitExit(t, failed)
+
+	c.mu.Lock()
+	restarts := len(c.restarts)
+	c.mu.Unlock()
+	if restarts != 1 {
+		t.Fatalf("plugin restarted %d times", restarts)
+	}
+	if cmd := process(c); cmd == nil || cmd == failed {
+		t.Fatal("plugin not replaced")
+	}
+}
+
+func TestRestartClosed(t *testing.T) {
+	c, cleanup := startFakePlugin(t)
+	defer cleanup()
+
+	failed := process(c)
+	done := make(chan struct{})
+	go func() {
+		c.restart(failed)
+		close(done)
+	}()
+	c.Close()
+	<-done
+
+	if cmd := process(c); cmd != nil {
+		waitExit(t, cmd)
+		t.Fatal("process started after Close")
+	}
+	waitExit(t, failed)
+	if err := c.restart(nil); err != ErrStopped {
+		t.Fatalf("expected ErrStopped, got %v", err)
+	}
+}
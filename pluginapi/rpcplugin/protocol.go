@@ -0,0 +1,46 @@
+// Package rpcplugin runs airhorn plugins as separate processes.
+//
+// The plugin process serves a pluginapi.Plugin with Serve, it talks to the bot
+// over its standard input and output with JSON-RPC (net/rpc/jsonrpc). Anything
+// written to the standard error is logged by the bot. The bot starts the
+// plugin with Start, the returned Client is a pluginapi.Plugin.
+//
+// Sounds are pulled by the bot: Open starts a stream and Frames returns the
+// next opus frames until EOF is set.
+package rpcplugin
+
+import (
+	"gitlab.com/Shywim/airhornbot/pluginapi"
+)
+
+// Name of the RPC service served by plugins
+const serviceName = "Plugin"
+
+// Empty is used by methods without arguments or result
+type Empty struct{}
+
+// HelloReply is the reply of the Hello method, called right after the
+// plugin process starts
+type HelloReply struct {
+	APIVersion int
+	Metadata   pluginapi.Metadata
+}
+
+// OpenReply is the reply of the Open method
+type OpenReply struct {
+	StreamID uint64
+}
+
+// FramesArgs are the arguments of the Frames method
+type FramesArgs struct {
+	StreamID uint64
+	// Maximum number of frames to return
+	Max int
+}
+
+// FramesReply is the reply of the Frames method
+type FramesReply struct {
+	Frames [][]byte
+	// EOF is set once the last frame of the stream has been returned
+	EOF bool
+}
@@ -0,0 +1,143 @@
+package rpcplugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+
+	"gitlab.com/Shywim/airhornbot/pluginapi"
+)
+
+// Maximum number of frames returned by a single Frames call
+const maxFramesPerCall = 250
+
+var errUnknownStream = errors.New("rpcplugin: unknown stream")
+
+// Serve serves a plugin over the standard input and output of the process,
+// it returns when the bot closes the connection
+func Serve(p pluginapi.Plugin) error {
+	return ServeConn(p, stdio{})
+}
+
+// ServeConn serves a plugin over conn
+func ServeConn(p pluginapi.Plugin, conn io.ReadWriteCloser) error {
+	server := rpc.NewServer()
+	err := server.RegisterName(serviceName, &service{
+		plugin:  p,
+		streams: make(map[uint64]*serverStream),
+	})
+	if err != nil {
+		return err
+	}
+
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	return nil
+}
+
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdio) Close() error                { return os.Stdin.Close() }
+
+type serverStream struct {
+	stream pluginapi.Stream
+	cancel context.CancelFunc
+}
+
+// service exposes a plugin through net/rpc, its exported methods are the
+// methods of the protocol
+type service struct {
+	plugin pluginapi.Plugin
+
+	mu      sync.Mutex
+	nextID  uint64
+	streams map[uint64]*serverStream
+}
+
+func (s *service) Hello(_ Empty, reply *HelloReply) error {
+	reply.APIVersion = pluginapi.Version
+	reply.Metadata = s.plugin.Metadata()
+	return nil
+}
+
+func (s *service) Init(cfg pluginapi.Config, _ *Empty) error {
+	return s.plugin.Init(cfg)
+}
+
+func (s *service) Commands(_ Empty, reply *[]pluginapi.Command) error {
+	*reply = s.plugin.Commands()
+	return nil
+}
+
+func (s *service) Handles(command string, reply *bool) error {
+	*reply = s.plugin.Handles(command)
+	return nil
+}
+
+func (s *service) Ping(_ Empty, _ *Empty) error {
+	return nil
+}
+
+func (s *service) Open(req pluginapi.Request, reply *OpenReply) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := s.plugin.Sound(ctx, req)
+	if err != nil {
+		cancel()
+		return err
+	}
+	if stream == nil {
+		cancel()
+		return pluginapi.ErrNoSound
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	reply.StreamID = s.nextID
+	s.streams[s.nextID] = &serverStream{stream: stream, cancel: cancel}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *service) Frames(args FramesArgs, reply *FramesReply) error {
+	s.mu.Lock()
+	st, ok := s.streams[args.StreamID]
+	s.mu.Unlock()
+	if !ok {
+		return errUnknownStream
+	}
+
+	max := args.Max
+	if max <= 0 || max > maxFramesPerCall {
+		max = maxFramesPerCall
+	}
+
+	for len(reply.Frames) < max {
+		frame, err := st.stream.NextFrame()
+		if err == io.EOF {
+			reply.EOF = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+		reply.Frames = append(reply.Frames, frame)
+	}
+	return nil
+}
+
+func (s *service) Close(streamID uint64, _ *Empty) error {
+	s.mu.Lock()
+	st, ok := s.streams[streamID]
+	delete(s.streams, streamID)
+	s.mu.Unlock()
+	if !ok {
+		return errUnknownStream
+	}
+
+	st.cancel()
+	return st.stream.Close()
+}
@@ -0,0 +1,65 @@
+// Command fakeplugin is the plugin run by the tests of rpcplugin. Its only
+// command is named after its process ID, "crash" makes it exit after writing
+// two lines on its standard error and "configured" tells whether Init was
+// called.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"gitlab.com/Shywim/airhornbot/pluginapi"
+	"gitlab.com/Shywim/airhornbot/pluginapi/rpcplugin"
+)
+
+type fakePlugin struct {
+	mu         sync.Mutex
+	configured bool
+}
+
+func (p *fakePlugin) Metadata() pluginapi.Metadata {
+	return pluginapi.Metadata{
+		Name:    "fake",
+		Version: "1.0.0",
+	}
+}
+
+func (p *fakePlugin) Init(cfg pluginapi.Config) error {
+	p.mu.Lock()
+	p.configured = true
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *fakePlugin) Commands() []pluginapi.Command {
+	return []pluginapi.Command{{Name: strconv.Itoa(os.Getpid())}}
+}
+
+func (p *fakePlugin) Handles(command string) bool {
+	switch command {
+	case "crash":
+		fmt.Fprint(os.Stderr, "crashing\nlast words")
+		os.Exit(1)
+	case "configured":
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.configured
+	}
+	return command == "beep"
+}
+
+func (p *fakePlugin) Sound(ctx context.Context, req pluginapi.Request) (pluginapi.Stream, error) {
+	if req.Command != "beep" {
+		return nil, pluginapi.ErrUnknownCommand
+	}
+	return pluginapi.FramesStream([][]byte{{1}, {2}, {3}}), nil
+}
+
+func main() {
+	if err := rpcplugin.Serve(&fakePlugin{}); err != nil {
+		os.Exit(1)
+	}
+}
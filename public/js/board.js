@@ -0,0 +1,33 @@
+(() => {
+  const board = document.getElementById('board');
+  const result = document.getElementById('board-result');
+  const buttons = board.querySelectorAll('.board-btn');
+
+  // ask the bot to play the sound, it answers with what it did
+  Array.prototype.forEach.call(buttons, (btn) => {
+    btn.onclick = () => {
+      const body = new FormData();
+      body.append('sound', btn.dataset.sound);
+
+      btn.disabled = true;
+      fetch(board.dataset.url, {
+        method: 'POST',
+        credentials: 'same-origin',
+        headers: { 'X-CSRF-Token': board.dataset.csrf },
+        body,
+      })
+        .then((resp) => resp.json())
+        .then((data) => {
+          result.textContent = data.message;
+          result.className = data.ok ? 'hint' : 'error';
+        })
+        .catch(() => {
+          result.textContent = 'Could not play this sound';
+          result.className = 'error';
+        })
+        .then(() => {
+          btn.disabled = false;
+        });
+    };
+  });
+})();
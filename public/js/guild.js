@@ -0,0 +1,48 @@
+(() => {
+  const buttons = document.querySelectorAll('.preview-btn');
+
+  // ask the server to pick a sound like the bot would
+  Array.prototype.forEach.call(buttons, (btn) => {
+    const result = btn.parentNode.querySelector('.preview-result');
+    btn.onclick = () => {
+      fetch(btn.dataset.url, { credentials: 'same-origin' })
+        .then((resp) => resp.json())
+        .then((data) => {
+          result.textContent = `${data.sound} (${data.chance.toFixed(1)}%)`;
+        })
+        .catch(() => {
+          result.textContent = 'Could not preview this command';
+        });
+    };
+  });
+})();
+
+(() => {
+  const status = document.getElementById('bot-status');
+  if (!status) {
+    return;
+  }
+
+  // ask the bot where it is and what it plays
+  fetch(status.dataset.url, { credentials: 'same-origin' })
+    .then((resp) => resp.json())
+    .then((data) => {
+      if (!data.online) {
+        status.textContent = 'The bot is offline';
+      } else if (!data.voice.connected) {
+        status.textContent = 'The bot is not in a voice channel';
+      } else {
+        let text = `The bot is in ${data.voice.channelName || data.voice.channelId}`;
+        if (data.player.sound) {
+          text += `, playing ${data.player.sound}`;
+        }
+        if (data.player.queue.length) {
+          text += ` (${data.player.queue.length} waiting)`;
+        }
+        status.textContent = text;
+      }
+    })
+    .catch(() => {
+      status.textContent = 'Could not get the status of the bot';
+    });
+})();
@@ -0,0 +1,39 @@
+(() => {
+  const weight = document.getElementById('weight');
+  const commands = document.querySelector('input[name="commands"]');
+  const chances = document.getElementById('chances');
+  const otherWeights = window.otherWeights || {};
+
+  // same computation as the bot: weight / total weight of the command
+  const update = () => {
+    const w = parseInt(weight.value, 10) || 0;
+    const parts = commands.value.split(',')
+      .map((c) => c.trim().replace(/^!/, '').toLowerCase())
+      .filter((c) => c !== '');
+
+    chances.textContent = parts.map((c) => {
+      const total = w + (otherWeights[c] || 0);
+      const chance = total > 0 ? (w * 100 / total) : 0;
+      return `!${c} ${chance.toFixed(1)}%`;
+    }).join(', ');
+  };
+
+  weight.oninput = update;
+  commands.oninput = update;
+  update();
+
+  // let the browser play the chosen file before it is uploaded, dca files
+  // can't be played
+  const file = document.getElementById('file');
+  const preview = document.getElementById('file-preview');
+  if (file && preview) {
+    file.onchange = () => {
+      const f = file.files[0];
+      if (preview.src) {
+        URL.revokeObjectURL(preview.src);
+      }
+      preview.hidden = !f || /\.dca$/i.test(f.name);
+      preview.src = preview.hidden ? '' : URL.createObjectURL(f);
+    };
+  }
+})();
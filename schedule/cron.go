@@ -0,0 +1,174 @@
+// Package schedule parses cron specs and runs a job every minute on a clock
+// which can be faked.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron spec: "minute hour day-of-month month day-of-week",
+// e.g. "0 18 * * fri" for every Friday at 18:00. Fields accept "*", lists
+// ("1,15"), ranges ("1-5") and steps ("*/15"). Months and days of the week can
+// be given by their first three letters, Sunday is 0 or 7.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+
+	// "*" day fields, when both are restricted either one matching is enough
+	anyDom, anyDow bool
+}
+
+type field struct {
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField = field{0, 59, nil}
+	hourField   = field{0, 23, nil}
+	domField    = field{1, 31, nil}
+	monthField  = field{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField    = field{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// Parse parses a cron spec
+func Parse(spec string) (*Cron, error) {
+	fields := strings.Fields(strings.ToLower(spec))
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("a schedule has 5 fields (minute hour day month weekday), not %d", len(fields))
+	}
+
+	c := &Cron{
+		anyDom: fields[2] == "*",
+		anyDow: fields[4] == "*",
+	}
+	var err error
+	if c.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if c.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if c.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if c.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if c.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+
+	// Sunday is both 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parse returns the bits of the values of a field
+func (f field) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := f.min, f.max
+		if part != "*" {
+			var err error
+			if i := strings.IndexByte(part, '-'); i >= 0 {
+				if lo, err = f.value(part[:i]); err != nil {
+					return 0, err
+				}
+				if hi, err = f.value(part[i+1:]); err != nil {
+					return 0, err
+				}
+			} else {
+				if lo, err = f.value(part); err != nil {
+					return 0, err
+				}
+				hi = lo
+				if step > 1 {
+					hi = f.max
+				}
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %q", part)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or a name of a field
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if s == name {
+			return i + f.min, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%q is not between %d and %d", s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Match reports whether the minute of t matches the spec, in the location of t
+func (c *Cron) Match(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 ||
+		c.hour&(1<<uint(t.Hour())) == 0 ||
+		c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	return c.matchDay(t)
+}
+
+// Maximum time Next looks ahead, a spec like "0 0 30 2 *" never matches
+const maxLookahead = 5 * 366 * 24 * time.Hour
+
+// Next returns the first minute after t matching the spec, in the location of
+// t. It returns the zero time if there is none in the next years.
+func (c *Cron) Next(t time.Time) time.Time {
+	end := t.Add(maxLookahead)
+	for t = t.Truncate(time.Minute).Add(time.Minute); t.Before(end); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay reports whether the day of t matches the day of month or the day of
+// week of the spec
+func (c *Cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,161 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// Monday, October 19 2026
+var monday = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"* * * * *", true},
+		{"0 18 * * fri", true},
+		{"*/15 9-17 1,15 * mon-fri", true},
+		{"0 0 * jan-mar,dec 0,7", true},
+		{"  0   0  *  *  *  ", true},
+		{"0 0 * * SUN", true},
+		{"10-50/20 * * * *", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * 32 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"*/x * * * *", false},
+		{"5-1 * * * *", false},
+		{"x * * * *", false},
+		{"* * * foo *", false},
+		{"* * * * sunday", false},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.spec)
+		if test.ok && err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.spec, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("Parse(%q) accepted an invalid spec", test.spec)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		spec    string
+		match   []time.Time
+		noMatch []time.Time
+	}{
+		// steps and ranges
+		{"*/15 * * * *", []time.Time{at(10, 19, 0, 0), at(10, 19, 5, 45)}, []time.Time{at(10, 19, 0, 10)}},
+		{"10-50/20 * * * *", []time.Time{at(10, 19, 0, 10), at(10, 19, 0, 30), at(10, 19, 0, 50)}, []time.Time{at(10, 19, 0, 20)}},
+		{"5/20 * * * *", []time.Time{at(10, 19, 0, 5), at(10, 19, 0, 45)}, []time.Time{at(10, 19, 0, 0)}},
+		{"0 9-17 * * *", []time.Time{at(10, 19, 9, 0), at(10, 19, 17, 0)}, []time.Time{at(10, 19, 8, 0), at(10, 19, 18, 0)}},
+		{"0 0 1,15 * *", []time.Time{at(10, 1, 0, 0), at(10, 15, 0, 0)}, []time.Time{at(10, 2, 0, 0)}},
+		// names
+		{"0 0 * jan-mar *", []time.Time{at(2, 10, 0, 0)}, []time.Time{at(4, 10, 0, 0)}},
+		{"0 18 * * fri", []time.Time{at(10, 23, 18, 0)}, []time.Time{at(10, 22, 18, 0)}},
+		{"0 0 * * mon-wed", []time.Time{at(10, 19, 0, 0), at(10, 21, 0, 0)}, []time.Time{at(10, 22, 0, 0)}},
+		// Sunday is 0 and 7
+		{"0 0 * * 7", []time.Time{at(10, 18, 0, 0)}, []time.Time{at(10, 19, 0, 0)}},
+		{"0 0 * * 0", []time.Time{at(10, 18, 0, 0)}, []time.Time{at(10, 24, 0, 0)}},
+		{"0 0 * * 5-7", []time.Time{at(10, 23, 0, 0), at(10, 24, 0, 0), at(10, 25, 0, 0)}, []time.Time{at(10, 19, 0, 0)}},
+		// restricted day of month and day of week: either one matches
+		{"0 0 13 * fri", []time.Time{at(10, 13, 0, 0), at(10, 23, 0, 0)}, []time.Time{at(10, 14, 0, 0)}},
+		// one day field restricted: it must match
+		{"0 0 13 * *", []time.Time{at(10, 13, 0, 0)}, []time.Time{at(10, 23, 0, 0)}},
+		{"0 0 * * fri", []time.Time{at(10, 23, 0, 0)}, []time.Time{at(10, 13, 0, 0)}},
+	}
+
+	for _, test := range tests {
+		c, err := Parse(test.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", test.spec, err)
+		}
+		for _, m := range test.match {
+			if !c.Match(m) {
+				t.Errorf("%q doesn't match %v", test.spec, m)
+			}
+		}
+		for _, m := range test.noMatch {
+			if c.Match(m) {
+				t.Errorf("%q matches %v", test.spec, m)
+			}
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"0 18 * * fri", monday, time.Date(2026, 10, 23, 18, 0, 0, 0, time.UTC)},
+		{"*/15 9-17 1,15 * *", monday, time.Date(2026, 11, 1, 9, 0, 0, 0, time.UTC)},
+		{"* * * * *", monday.Add(30 * time.Second), monday.Add(time.Minute)},
+		{"0 12 * * *", monday, time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", monday, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", monday, time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", monday, time.Date(2026, 10, 23, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", monday, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// never matches
+		{"0 0 30 2 *", monday, time.Time{}},
+	}
+
+	for _, test := range tests {
+		c, err := Parse(test.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", test.spec, err)
+		}
+		if got := c.Next(test.from); !got.Equal(test.want) {
+			t.Errorf("Next(%q, %v) = %v, want %v", test.spec, test.from, got, test.want)
+		}
+	}
+}
+
+func TestNextDST(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	// clocks go from 02:00 to 03:00 on March 29 2026 in Paris
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 0, 0, paris)
+	}
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		// a minute in the gap doesn't happen that day
+		{"30 2 * * *", at(28, 12, 0), at(30, 2, 30)},
+		// the first hour after the gap
+		{"0 3 * * *", at(29, 0, 0), at(29, 3, 0)},
+		{"*/30 * * * *", at(29, 1, 45), at(29, 3, 0)},
+		// a day ends an hour early
+		{"0 12 * * *", at(28, 12, 0), at(29, 12, 0)},
+	}
+
+	for _, test := range tests {
+		c, err := Parse(test.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", test.spec, err)
+		}
+		if got := c.Next(test.from); !got.Equal(test.want) {
+			t.Errorf("Next(%q, %v) = %v, want %v", test.spec, test.from, got, test.want)
+		}
+	}
+}
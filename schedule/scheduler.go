@@ -0,0 +1,54 @@
+package schedule
+
+import (
+	"time"
+)
+
+// Clock tells the time to a Scheduler, tests give it a fake one
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is the clock of the system
+type RealClock struct{}
+
+// Now returns the current time
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for d to elapse and then sends the current time
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Scheduler calls Tick at the start of every minute
+type Scheduler struct {
+	Clock Clock
+
+	// Tick is given the minute which just started, minutes missed while Tick
+	// was running or the clock jumped are skipped
+	Tick func(minute time.Time)
+}
+
+// Run calls Tick every minute until stop is closed
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	last := s.Clock.Now().Truncate(time.Minute)
+	for {
+		next := last.Add(time.Minute)
+		select {
+		case <-stop:
+			return
+		case <-s.Clock.After(next.Sub(s.Clock.Now())):
+		}
+
+		minute := s.Clock.Now().Truncate(time.Minute)
+		if !minute.After(last) {
+			// woke up early
+			continue
+		}
+		last = minute
+		s.Tick(minute)
+	}
+}
@@ -0,0 +1,204 @@
+package schedule_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/Shywim/airhornbot/schedule"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+// fakeClock is a clock whose time only changes with Advance
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+
+	// receives a value every time After is called
+	waiting chan struct{}
+}
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, waiting: make(chan struct{}, 1)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	c := make(chan time.Time, 1)
+	f.waiters = append(f.waiters, waiter{f.now.Add(d), c})
+	f.mu.Unlock()
+
+	f.waiting <- struct{}{}
+	return c
+}
+
+// Advance moves the time forward by d and returns how many waiters it woke
+func (f *fakeClock) Advance(d time.Duration) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	woken := 0
+	waiters := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.c <- f.now
+		woken++
+	}
+	f.waiters = waiters
+	return woken
+}
+
+// waitRun waits for the scheduler to wait for the next minute
+func (f *fakeClock) waitRun(t *testing.T) {
+	select {
+	case <-f.waiting:
+	case <-time.After(time.Second):
+		t.Fatal("the scheduler isn't waiting for the next minute")
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	start := time.Date(2026, 10, 19, 11, 58, 30, 0, time.UTC)
+	clock := newFakeClock(start)
+
+	schedules := []*service.Schedule{
+		{ID: "noon", At: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)},
+		{ID: "late", At: time.Date(2026, 10, 19, 12, 1, 30, 0, time.UTC)},
+		{ID: "missed", At: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		{ID: "even", Spec: "*/2 * * * *", Timezone: "UTC"},
+	}
+
+	var (
+		mu    sync.Mutex
+		ticks []time.Time
+		plays = make(map[string][]time.Time)
+	)
+	s := &schedule.Scheduler{
+		Clock: clock,
+		Tick: func(minute time.Time) {
+			mu.Lock()
+			defer mu.Unlock()
+			ticks = append(ticks, minute)
+
+			// one-shot schedules are removed once played, as the bot does
+			remaining := schedules[:0]
+			for _, sch := range schedules {
+				if sch.Due(minute) {
+					plays[sch.ID] = append(plays[sch.ID], minute)
+					if !sch.Recurring() {
+						continue
+					}
+				}
+				remaining = append(remaining, sch)
+			}
+			schedules = remaining
+		},
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop)
+		close(done)
+	}()
+
+	// 20 seconds at a time up to 12:05:10
+	clock.waitRun(t)
+	for i := 0; i < 20; i++ {
+		if clock.Advance(20*time.Second) > 0 {
+			clock.waitRun(t)
+		}
+	}
+	close(stop)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(ticks) != 7 {
+		t.Fatalf("%d ticks, want 7: %v", len(ticks), ticks)
+	}
+	for i, tick := range ticks {
+		want := time.Date(2026, 10, 19, 11, 59+i, 0, 0, time.UTC)
+		if !tick.Equal(want) {
+			t.Errorf("tick %d at %v, want %v", i, tick, want)
+		}
+	}
+
+	expected := map[string][]time.Time{
+		"noon": {time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)},
+		"late": {time.Date(2026, 10, 19, 12, 2, 0, 0, time.UTC)},
+		"even": {
+			time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+			time.Date(2026, 10, 19, 12, 2, 0, 0, time.UTC),
+			time.Date(2026, 10, 19, 12, 4, 0, 0, time.UTC),
+		},
+	}
+	// missed while the bot was down
+	if got := plays["missed"]; len(got) != 0 {
+		t.Errorf("missed played at %v", got)
+	}
+	for id, want := range expected {
+		got := plays[id]
+		if len(got) != len(want) {
+			t.Errorf("%s played at %v, want %v", id, got, want)
+			continue
+		}
+		for i := range want {
+			if !got[i].Equal(want[i]) {
+				t.Errorf("%s played at %v, want %v", id, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestSchedulerClockJump(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+
+	ticks := make(chan time.Time, 10)
+	s := &schedule.Scheduler{
+		Clock: clock,
+		Tick:  func(minute time.Time) { ticks <- minute },
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop)
+		close(done)
+	}()
+
+	// the minutes missed while the clock jumped are skipped
+	clock.waitRun(t)
+	clock.Advance(5*time.Minute + 10*time.Second)
+	clock.waitRun(t)
+	close(stop)
+	<-done
+	close(ticks)
+
+	var got []time.Time
+	for tick := range ticks {
+		got = append(got, tick)
+	}
+	want := time.Date(2026, 10, 19, 12, 5, 0, 0, time.UTC)
+	if len(got) != 1 || !got[0].Equal(want) {
+		t.Fatalf("ticks %v, want only %v", got, want)
+	}
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+	"gitlab.com/Shywim/airhornbot/codec"
+)
+
+const (
+	// Version of the archive format written by ExportGuildSounds
+	archiveVersion = 1
+
+	archiveManifestName = "manifest.json"
+
+	// Maximum size of the manifest of an archive
+	maxArchiveManifestSize = 1 << 20
+
+	// Maximum number of files in an archive: the audio of every sound and the
+	// manifest
+	maxArchiveFiles = MaxGuildSounds + 1
+)
+
+// MaxArchiveSize is the maximum size of an archive given to ImportGuildSounds,
+// enough for the audio of the most sounds a guild can have and the manifest
+const MaxArchiveSize = MaxGuildSounds*(MaxSoundFileSize+1024) + maxArchiveManifestSize
+
+// ConflictMode tells what to do when an imported sound has the name of a
+// sound already in the guild
+type ConflictMode string
+
+// Conflict modes of ImportGuildSounds
+const (
+	ConflictRename    ConflictMode = "rename"
+	ConflictSkip      ConflictMode = "skip"
+	ConflictOverwrite ConflictMode = "overwrite"
+)
+
+// ParseConflictMode returns the conflict mode named s
+func ParseConflictMode(s string) (ConflictMode, error) {
+	switch m := ConflictMode(s); m {
+	case ConflictRename, ConflictSkip, ConflictOverwrite:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown conflict mode %q", s)
+}
+
+type archiveManifest struct {
+	Version int            `json:"version"`
+	GuildID string         `json:"guildId"`
+	Sounds  []archiveSound `json:"sounds"`
+}
+
+type archiveSound struct {
+	Name     string   `json:"name"`
+	Gif      string   `json:"gif"`
+	Weight   int      `json:"weight"`
+	Commands []string `json:"commands"`
+	Effects  string   `json:"effects,omitempty"`
+	Gain     float64  `json:"gain,omitempty"` // measured again on import
+	File     string   `json:"file"`
+}
+
+// ImportReport lists what happened to each sound of an imported archive
+type ImportReport struct {
+	Imported    []string
+	Renamed     []string
+	Overwritten []string
+	Skipped     []string
+	Errors      []string
+}
+
+// ExportGuildSounds writes a zip archive of the custom sounds of a guild:
+// a manifest.json and the audio file of every sound
+func ExportGuildSounds(guildID string, w io.Writer) error {
+	sounds, err := GetSoundsByGuild(guildID)
+	if err != nil {
+		return err
+	}
+
+	z := zip.NewWriter(w)
+	manifest := archiveManifest{
+		Version: archiveVersion,
+		GuildID: guildID,
+	}
+
+	for _, s := range sounds {
+		as := archiveSound{
+			Name:     s.Name,
+			Gif:      s.Gif,
+			Weight:   s.Weight,
+			Commands: s.Commands,
+			Effects:  s.Effects,
+			Gain:     s.Gain,
+			File:     path.Join("sounds", s.ID+".dca"),
+		}
+
+		if err = copyToArchive(z, as.File, AudioPath(s)); err != nil {
+			return err
+		}
+		manifest.Sounds = append(manifest.Sounds, as)
+	}
+
+	mw, err := z.Create(archiveManifestName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(manifest); err != nil {
+		return err
+	}
+
+	return z.Close()
+}
+
+func copyToArchive(z *zip.Writer, name, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// ImportGuildSounds adds the sounds of an archive made by ExportGuildSounds to
+// a guild. Sounds named like an existing sound are handled according to mode,
+// commands used by the default sounds of the guild are dropped. Sounds the
+// dashboard wouldn't accept are reported as errors.
+func ImportGuildSounds(guildID string, r io.ReaderAt, size int64, mode ConflictMode) (*ImportReport, error) {
+	if size > MaxArchiveSize {
+		return nil, errors.New("the archive is too large")
+	}
+
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(z.File) > maxArchiveFiles {
+		return nil, fmt.Errorf("the archive has more than %d files", maxArchiveFiles)
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+
+	manifest, err := readArchiveManifest(files[archiveManifestName])
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := GetSoundsByGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*Sound)
+	for _, s := range existing {
+		byName[s.Name] = s
+	}
+
+	defaults, err := GetDefaultSoundsByGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{}
+	for _, as := range manifest.Sounds {
+		sound := &Sound{
+			GuildID: guildID,
+			Name:    as.Name,
+			Gif:     as.Gif,
+			Weight:  as.Weight,
+		}
+		// effects of an archive made by another version are dropped if invalid
+		if effects, err := codec.ParseEffects(as.Effects); err == nil {
+			sound.Effects = effects.String()
+		}
+		seen := make(map[string]bool)
+		for _, c := range as.Commands {
+			if IsValidCommand(c) && !IsReservedCommand(c) && !seen[c] && len(FilterByCommand(c, defaults)) == 0 {
+				sound.Commands = append(sound.Commands, c)
+				seen[c] = true
+			}
+		}
+
+		if err = validateArchiveSound(sound); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", as.Name, err))
+			continue
+		}
+
+		f := files[as.File]
+		if f == nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: missing audio file %s", as.Name, as.File))
+			continue
+		}
+
+		old, conflict := byName[sound.Name]
+		if (!conflict || mode == ConflictRename) && len(byName) >= MaxGuildSounds {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: the server already has the maximum of %d sounds", as.Name, MaxGuildSounds))
+			continue
+		}
+		renamed := false
+		if conflict {
+			switch mode {
+			case ConflictSkip:
+				report.Skipped = append(report.Skipped, sound.Name)
+				continue
+			case ConflictOverwrite:
+				sound.ID = old.ID
+				sound.FilePath = old.FilePath
+			default:
+				sound.Name = freeSoundName(sound.Name, byName)
+				renamed = true
+			}
+		}
+		if sound.FilePath == "" {
+			sound.FilePath = uuid.NewV4().String()
+		}
+
+		if err = extractAudio(f, sound.FilePath); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", as.Name, err))
+			continue
+		}
+		// the gain of the archive isn't trusted, the audio is measured again
+		if _, err = MeasureGain(sound); err != nil {
+			// the sound is still playable, only not normalized
+			log.WithFields(log.Fields{
+				"sound": sound.Name,
+				"error": err,
+			}).Warn("Couldn't measure the loudness of a sound")
+		}
+		if err = sound.Save(); err != nil {
+			return report, err
+		}
+		if conflict && !renamed {
+			// the audio was replaced, so is its gain
+			if err = sound.SaveGain(); err != nil {
+				return report, err
+			}
+		}
+		byName[sound.Name] = sound
+
+		switch {
+		case conflict && !renamed:
+			report.Overwritten = append(report.Overwritten, sound.Name)
+		case renamed:
+			report.Renamed = append(report.Renamed, as.Name+" → "+sound.Name)
+		default:
+			report.Imported = append(report.Imported, sound.Name)
+		}
+	}
+
+	return report, nil
+}
+
+func readArchiveManifest(f *zip.File) (*archiveManifest, error) {
+	if f == nil {
+		return nil, errors.New("the archive has no manifest")
+	}
+	if f.UncompressedSize64 > maxArchiveManifestSize {
+		return nil, errors.New("the manifest of the archive is too large")
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	manifest := &archiveManifest{}
+	if err = json.NewDecoder(io.LimitReader(rc, maxArchiveManifestSize)).Decode(manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Version != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+	return manifest, nil
+}
+
+// validateArchiveSound checks an imported sound as the dashboard checks the
+// sounds uploaded
+func validateArchiveSound(s *Sound) error {
+	if err := ValidateSoundName(s.Name); err != nil {
+		return err
+	}
+	if len(s.Commands) == 0 {
+		return errors.New("no usable command")
+	}
+	if err := ValidateCommands(s.Commands); err != nil {
+		return err
+	}
+	if err := ValidateWeight(s.Weight); err != nil {
+		return err
+	}
+	return ValidateGif(s.Gif)
+}
+
+func extractAudio(f *zip.File, filePath string) error {
+	if f.UncompressedSize64 > MaxSoundFileSize {
+		return errors.New("audio file too large")
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return SaveAudio(io.LimitReader(rc, MaxSoundFileSize), filePath)
+}
+
+// freeSoundName returns name with a number appended so that no sound uses it
+func freeSoundName(name string, used map[string]*Sound) string {
+	for i := 2; ; i++ {
+		n := fmt.Sprintf("%s (%d)", name, i)
+		if _, ok := used[n]; !ok {
+			return n
+		}
+	}
+}
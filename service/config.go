@@ -2,6 +2,8 @@ package service
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -21,7 +23,36 @@ type Cfg struct {
 	DiscordClientSecret string
 	DataPath            string
 	PluginPath          string
+	SoundsManifest      string
 	DiscordOwnerID      string
+
+	// Shard run by this process, any free one if negative
+	DiscordShardID int
+	// Shards the guilds of the bot are split in, the count recommended by
+	// Discord if 0
+	DiscordShardCount int
+
+	// Public URL the web dashboard is reached at, used to build OAuth redirects
+	BaseURL string
+	// Other public URLs serving the same dashboard (e.g. an alternative domain)
+	AltURLs []string
+
+	// Settings of each plugin, from the [plugin.<name>] sections
+	PluginSettings map[string]map[string]interface{}
+
+	// Longest a sound can play, longer sounds are cut
+	MaxSoundDuration time.Duration
+
+	// Most sounds playing at once in a guild in mix mode
+	MaxVoices int
+
+	// Least time between two sounds played when a user joins or leaves a
+	// voice channel
+	WalkOnCooldown time.Duration
+
+	// Time the sounds playing and the requests being served have to end
+	// when the bot or the web app stops
+	ShutdownTimeout time.Duration
 }
 
 var config Cfg
@@ -31,6 +62,14 @@ func LoadConfig() (Cfg, error) {
 	viper.SetConfigName("config")
 	viper.AddConfigPath("config")
 	viper.AddConfigPath("/etc/airhornbot")
+	viper.SetDefault("web.base_url", "http://localhost:14000")
+	viper.SetDefault("data.sounds_manifest", "audio/sounds.toml")
+	viper.SetDefault("discord.shard_id", -1)
+	viper.SetDefault("discord.shard_count", 1)
+	viper.SetDefault("bot.max_sound_duration", "5m")
+	viper.SetDefault("bot.max_voices", 4)
+	viper.SetDefault("bot.walk_on_cooldown", "2m")
+	viper.SetDefault("shutdown_timeout", "10s")
 
 	err := viper.ReadInConfig()
 	if err != nil {
@@ -51,7 +90,22 @@ func LoadConfig() (Cfg, error) {
 	cfg.DiscordClientSecret = viper.GetString("discord.client_secret")
 	cfg.DataPath = viper.GetString("data.data_path")
 	cfg.PluginPath = viper.GetString("data.plugins_path")
+	cfg.SoundsManifest = viper.GetString("data.sounds_manifest")
+	cfg.PluginSettings = make(map[string]map[string]interface{})
+	for name := range viper.GetStringMap("plugin") {
+		cfg.PluginSettings[name] = viper.GetStringMap("plugin." + name)
+	}
 	cfg.DiscordOwnerID = viper.GetString("discord.owner_id")
+	cfg.DiscordShardID = viper.GetInt("discord.shard_id")
+	cfg.DiscordShardCount = viper.GetInt("discord.shard_count")
+	cfg.MaxSoundDuration = viper.GetDuration("bot.max_sound_duration")
+	cfg.MaxVoices = viper.GetInt("bot.max_voices")
+	cfg.WalkOnCooldown = viper.GetDuration("bot.walk_on_cooldown")
+	cfg.ShutdownTimeout = viper.GetDuration("shutdown_timeout")
+	cfg.BaseURL = strings.TrimSuffix(viper.GetString("web.base_url"), "/")
+	for _, u := range viper.GetStringSlice("web.alt_urls") {
+		cfg.AltURLs = append(cfg.AltURLs, strings.TrimSuffix(u, "/"))
+	}
 
 	if cfg.DBDriver == "mysql" {
 		cfg.DBHost = fmt.Sprintf("tcp(%s:%s)", cfg.DBHost, cfg.DBPort)
@@ -61,7 +115,7 @@ func LoadConfig() (Cfg, error) {
 
 	config = cfg
 
-	go initDb()
+	initDb()
 
 	return cfg, nil
 }
@@ -49,13 +49,17 @@ func initDb() {
 		"name VARCHAR(255)," +
 		"gif VARCHAR(255)," +
 		"weight INTEGER," +
-		"filepath VARCHAR(255)" +
+		"filepath VARCHAR(255)," +
+		"effects VARCHAR(255) DEFAULT ''," +
+		"gain REAL DEFAULT 0" +
 		")")
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
 		}).Warn("Error creating tables")
 	}
+	addColumn("sound", "effects", "VARCHAR(255) DEFAULT ''")
+	addColumn("sound", "gain", "REAL DEFAULT 0")
 
 	_, err = db.Exec("CREATE TABLE IF NOT EXISTS command (" +
 		"id " + primaryKeyType + "," +
@@ -69,6 +73,111 @@ func initDb() {
 			"error": err,
 		}).Warn("Error creating tables")
 	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS sound_override (" +
+		"id " + primaryKeyType + "," +
+		"guildId VARCHAR(255)," +
+		"soundName VARCHAR(255)," +
+		"disabled INTEGER," +
+		"weight INTEGER," +
+		"commands VARCHAR(255)" +
+		")")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Error creating tables")
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS guild_settings (" +
+		"id " + primaryKeyType + "," +
+		"guildId VARCHAR(255)," +
+		"volume INTEGER," +
+		"mix INTEGER DEFAULT 0," +
+		"control VARCHAR(32) DEFAULT 'anyone'," +
+		"controlRole VARCHAR(255) DEFAULT ''," +
+		"walkOn INTEGER DEFAULT 0" +
+		")")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Error creating tables")
+	}
+	addColumn("guild_settings", "mix", "INTEGER DEFAULT 0")
+	addColumn("guild_settings", "control", "VARCHAR(32) DEFAULT 'anyone'")
+	addColumn("guild_settings", "controlRole", "VARCHAR(255) DEFAULT ''")
+	addColumn("guild_settings", "walkOn", "INTEGER DEFAULT 0")
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS user_sound (" +
+		"id " + primaryKeyType + "," +
+		"guildId VARCHAR(255)," +
+		"userId VARCHAR(255)," +
+		"event VARCHAR(16)," +
+		"soundId VARCHAR(255)," +
+		"defaultName VARCHAR(255)" +
+		")")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Error creating tables")
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS schedule (" +
+		"id " + primaryKeyType + "," +
+		"guildId VARCHAR(255)," +
+		"channel VARCHAR(255)," +
+		"command VARCHAR(255)," +
+		"spec VARCHAR(255)," +
+		"at BIGINT DEFAULT 0," +
+		"timezone VARCHAR(64)" +
+		")")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Error creating tables")
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS sound_trigger (" +
+		"id " + primaryKeyType + "," +
+		"guildId VARCHAR(255)," +
+		"kind VARCHAR(16)," +
+		"pattern VARCHAR(255)," +
+		"command VARCHAR(255)," +
+		"cooldown INTEGER" +
+		")")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Error creating tables")
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS guild_plugin (" +
+		"id " + primaryKeyType + "," +
+		"guildId VARCHAR(255)," +
+		"pluginName VARCHAR(255)," +
+		"disabled INTEGER" +
+		")")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Error creating tables")
+	}
+}
+
+// addColumn adds a column to a table created by an older version, if it is
+// missing. def is the SQL type and default of the column.
+func addColumn(table, column, def string) {
+	if _, err := db.Exec("SELECT " + column + " FROM " + table + " WHERE 1 = 0"); err == nil {
+		return
+	}
+
+	_, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + def)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"table":  table,
+			"column": column,
+		}).Warn("Error adding column")
+	}
 }
 
 func getDB() *sqlx.DB {
@@ -18,12 +18,32 @@ type Guild struct {
 	Name   string   `json:"name"`
 	Icon   string   `json:"icon"`
 	Sounds []*Sound `json:"sounds"`
+
+	// Default sounds as configured in the guild
+	Defaults []*GuildDefaultSound `json:"-"`
+
+	// Sounds available in the guild, default ones included, by command
+	Pools []*CommandPool `json:"-"`
+
+	// Plugins loaded by the bot as configured in the guild
+	Plugins []*GuildPlugin `json:"-"`
+
+	Settings *GuildSettings `json:"-"`
+
+	// Sounds played at set times
+	Schedules []*Schedule `json:"-"`
+
+	// Sounds played on keywords and reactions
+	Triggers []*Trigger `json:"-"`
 }
 
 // UserGuilds represents a user's guilds
 type UserGuilds struct {
 	AirhornGuilds []*Guild
 	BoringGuilds  []*Guild
+
+	// Guilds where the user can choose their join and leave sounds
+	WalkOnGuilds []*Guild
 }
 
 // AddGuild register a new guild to use Airhorn
@@ -58,6 +78,39 @@ func GetGuildWithSounds(session *discordgo.Session, gID string) (Guild, error) {
 		}
 		guild.Sounds = sounds
 
+		guild.Defaults, err = GetGuildDefaultSounds(g.ID)
+		if err != nil {
+			return Guild{}, err
+		}
+
+		var enabled []*Sound
+		for _, d := range guild.Defaults {
+			if !d.Disabled {
+				enabled = append(enabled, d.Sound)
+			}
+		}
+		guild.Pools = CommandPools(append(enabled, sounds...))
+
+		guild.Plugins, err = GetGuildPlugins(g.ID)
+		if err != nil {
+			return Guild{}, err
+		}
+
+		guild.Settings, err = GetGuildSettings(g.ID)
+		if err != nil {
+			return Guild{}, err
+		}
+
+		guild.Schedules, err = GetSchedules(g.ID)
+		if err != nil {
+			return Guild{}, err
+		}
+
+		guild.Triggers, err = GetTriggers(g.ID)
+		if err != nil {
+			return Guild{}, err
+		}
+
 		return guild, nil
 	}
 	return Guild{}, errors.New("no guild found")
@@ -70,8 +123,14 @@ func GetGuildsWithSounds(session *discordgo.Session) (interface{}, error) {
 		return nil, err
 	}
 
+	walkOn, err := GetWalkOnGuilds()
+	if err != nil {
+		return nil, err
+	}
+
 	var airhornGuilds []*Guild
 	var boringGuilds []*Guild
+	var walkOnGuilds []*Guild
 	for _, g := range guilds {
 		guild := &Guild{
 			ID:   g.ID,
@@ -80,6 +139,10 @@ func GetGuildsWithSounds(session *discordgo.Session) (interface{}, error) {
 				g.ID, g.Icon),
 		}
 
+		if walkOn[g.ID] {
+			walkOnGuilds = append(walkOnGuilds, guild)
+		}
+
 		if g.Permissions&permAdministrator != 0 {
 			hasAirhorn, err := GuildHasAirhorn(g.ID)
 			if err != nil {
@@ -102,6 +165,7 @@ func GetGuildsWithSounds(session *discordgo.Session) (interface{}, error) {
 	return &UserGuilds{
 		AirhornGuilds: airhornGuilds,
 		BoringGuilds:  boringGuilds,
+		WalkOnGuilds:  walkOnGuilds,
 	}, nil
 }
 
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// DefaultSounds are a set of default sounds available to every servers, they
+// are loaded from the sounds manifest by LoadLibrary
+var DefaultSounds []*Sound
+
+// DefaultPacks are the packs of the sounds manifest
+var DefaultPacks []*Pack
+
+var commandPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// ReservedCommands control the sounds playing, sounds can't use them
+var ReservedCommands = []string{"skip", "stop", "queue", "clear"}
+
+// Pack is a group of default sounds sharing commands
+type Pack struct {
+	Name     string
+	Commands []string
+	Sounds   []*Sound
+}
+
+type manifestSound struct {
+	Name     string   `mapstructure:"name"`
+	Commands []string `mapstructure:"commands"`
+	Weight   int      `mapstructure:"weight"`
+	Gif      string   `mapstructure:"gif"`
+	File     string   `mapstructure:"file"`
+}
+
+type manifestPack struct {
+	Name     string          `mapstructure:"name"`
+	Commands []string        `mapstructure:"commands"`
+	Sounds   []manifestSound `mapstructure:"sound"`
+}
+
+// LoadLibrary reads the sounds manifest (TOML or JSON) and replaces the
+// default sounds. An invalid manifest is an error, sounds whose file is
+// missing are only reported and skipped.
+func LoadLibrary(manifestPath string) error {
+	packs, err := ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	var sounds []*Sound
+	for _, p := range packs {
+		available := p.Sounds[:0]
+		for _, s := range p.Sounds {
+			if _, err := os.Stat(s.FilePath); err != nil {
+				log.WithFields(log.Fields{
+					"sound": s.Name,
+					"file":  s.FilePath,
+					"error": err,
+				}).Warn("Sound file is missing, skipping it")
+				continue
+			}
+			available = append(available, s)
+		}
+		p.Sounds = available
+		sounds = append(sounds, available...)
+	}
+
+	log.WithFields(log.Fields{
+		"packs":  len(packs),
+		"sounds": len(sounds),
+	}).Info("Loaded sounds library")
+
+	DefaultPacks = packs
+	DefaultSounds = sounds
+	return nil
+}
+
+// ReadManifest reads and validates a sounds manifest, file paths of the
+// sounds are resolved relative to the manifest
+func ReadManifest(manifestPath string) ([]*Pack, error) {
+	v := viper.New()
+	v.SetConfigFile(manifestPath)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var manifest []manifestPack
+	if err := v.UnmarshalKey("pack", &manifest); err != nil {
+		return nil, err
+	}
+	if len(manifest) == 0 {
+		return nil, fmt.Errorf("%s: no sound pack found", manifestPath)
+	}
+
+	dir := filepath.Dir(manifestPath)
+	names := make(map[string]bool)
+	var packs []*Pack
+	for i, mp := range manifest {
+		if mp.Name == "" {
+			return nil, fmt.Errorf("%s: pack #%d has no name", manifestPath, i+1)
+		}
+		if err := checkCommands(mp.Commands); err != nil {
+			return nil, fmt.Errorf("%s: pack %s: %v", manifestPath, mp.Name, err)
+		}
+
+		pack := &Pack{Name: mp.Name, Commands: mp.Commands}
+		for _, ms := range mp.Sounds {
+			if ms.Name == "" || ms.File == "" {
+				return nil, fmt.Errorf("%s: pack %s: every sound needs a name and a file", manifestPath, mp.Name)
+			}
+			if names[ms.Name] {
+				return nil, fmt.Errorf("%s: sound %s is declared twice", manifestPath, ms.Name)
+			}
+			names[ms.Name] = true
+
+			if ms.Weight < 0 {
+				return nil, fmt.Errorf("%s: sound %s has a negative weight", manifestPath, ms.Name)
+			} else if ms.Weight == 0 {
+				ms.Weight = 1
+			}
+
+			commands := mp.Commands
+			if len(ms.Commands) > 0 {
+				if err := checkCommands(ms.Commands); err != nil {
+					return nil, fmt.Errorf("%s: sound %s: %v", manifestPath, ms.Name, err)
+				}
+				commands = ms.Commands
+			}
+
+			file := ms.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+
+			pack.Sounds = append(pack.Sounds, &Sound{
+				Name:           ms.Name,
+				Gif:            ms.Gif,
+				Weight:         ms.Weight,
+				Commands:       commands,
+				CommandsString: strings.Join(commands, ", "),
+				FilePath:       file,
+			})
+		}
+		packs = append(packs, pack)
+	}
+
+	return packs, nil
+}
+
+// IsValidCommand checks a command only has lowercase letters, digits, "-" and
+// "_", and is at most 32 characters long
+func IsValidCommand(c string) bool {
+	return commandPattern.MatchString(c)
+}
+
+// IsReservedCommand checks whether a command is one of ReservedCommands
+func IsReservedCommand(c string) bool {
+	for _, r := range ReservedCommands {
+		if c == r {
+			return true
+		}
+	}
+	return false
+}
+
+// AudioPath returns the path of the audio file of a sound, default sounds
+// have no ID and their path is already resolved
+func AudioPath(s *Sound) string {
+	if s.ID == "" {
+		return s.FilePath
+	}
+	return filepath.Join(config.DataPath, s.FilePath)
+}
+
+func checkCommands(commands []string) error {
+	if len(commands) == 0 {
+		return fmt.Errorf("no command")
+	}
+	for _, c := range commands {
+		if !IsValidCommand(c) || IsReservedCommand(c) {
+			return fmt.Errorf("invalid command %q", c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"io"
+	"os"
+
+	"github.com/jonas747/dca"
+	"gitlab.com/Shywim/airhornbot/codec"
+	"gitlab.com/Shywim/airhornbot/codec/opus"
+)
+
+// MeasureGain measures the loudness of the audio file of a sound, in dBFS,
+// and sets the gain of the sound normalizing it
+func MeasureGain(s *Sound) (float64, error) {
+	file, err := os.Open(AudioPath(s))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var frames [][]byte
+	decoder := dca.NewDecoder(file)
+	for {
+		frame, err := decoder.OpusFrame()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		frames = append(frames, frame)
+	}
+
+	loudness, err := opus.Loudness(frames)
+	if err != nil {
+		return 0, err
+	}
+	s.Gain = codec.NormalizationGain(loudness)
+	return loudness, nil
+}
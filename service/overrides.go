@@ -0,0 +1,154 @@
+package service
+
+import (
+	"strings"
+)
+
+// SoundOverride customizes a default sound for a guild
+type SoundOverride struct {
+	GuildID   string
+	SoundName string
+	Disabled  bool
+
+	// Weight replaces the default weight, 0 keeps the default one
+	Weight int
+
+	// Commands replace the default commands, empty keeps the default ones
+	Commands []string
+}
+
+// GuildDefaultSound is a default sound as configured in a guild
+type GuildDefaultSound struct {
+	*Sound
+
+	Disabled   bool
+	Overridden bool
+}
+
+// Save replaces the override of a default sound in the db
+func (o *SoundOverride) Save() error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	q := tx.Rebind("DELETE FROM sound_override WHERE guildId = ? AND soundName = ?")
+	_, err = tx.Exec(q, o.GuildID, o.SoundName)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	disabled := 0
+	if o.Disabled {
+		disabled = 1
+	}
+	q = tx.Rebind("INSERT INTO sound_override (guildId, soundName, disabled, weight, commands) VALUES (?, ?, ?, ?, ?)")
+	_, err = tx.Exec(q, o.GuildID, o.SoundName, disabled, o.Weight, strings.Join(o.Commands, ","))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSoundOverride restores the default settings of a default sound in a guild
+func DeleteSoundOverride(guildID, soundName string) error {
+	q := db.Rebind("DELETE FROM sound_override WHERE guildId = ? AND soundName = ?")
+	_, err := db.Exec(q, guildID, soundName)
+	return err
+}
+
+// GetSoundOverrides returns the overrides of a guild by sound name
+func GetSoundOverrides(guildID string) (map[string]*SoundOverride, error) {
+	q := db.Rebind("SELECT soundName, disabled, weight, commands FROM sound_override WHERE guildId = ?")
+	rows, err := db.Query(q, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]*SoundOverride)
+	for rows.Next() {
+		var (
+			disabled int
+			commands string
+		)
+		o := &SoundOverride{GuildID: guildID}
+		if err = rows.Scan(&o.SoundName, &disabled, &o.Weight, &commands); err != nil {
+			return nil, err
+		}
+
+		o.Disabled = disabled != 0
+		for _, c := range strings.Split(commands, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				o.Commands = append(o.Commands, c)
+			}
+		}
+		overrides[o.SoundName] = o
+	}
+
+	return overrides, rows.Err()
+}
+
+// ApplyOverrides returns a copy of the default sounds with the overrides applied
+func ApplyOverrides(defaults []*Sound, overrides map[string]*SoundOverride) []*GuildDefaultSound {
+	r := make([]*GuildDefaultSound, len(defaults))
+	for i, d := range defaults {
+		sound := *d
+		gds := &GuildDefaultSound{Sound: &sound}
+
+		if o, ok := overrides[d.Name]; ok {
+			gds.Overridden = true
+			gds.Disabled = o.Disabled
+			if o.Weight > 0 {
+				sound.Weight = o.Weight
+			}
+			if len(o.Commands) > 0 {
+				sound.Commands = o.Commands
+			}
+		}
+		sound.CommandsString = strings.Join(sound.Commands, ", ")
+
+		r[i] = gds
+	}
+	return r
+}
+
+// GetGuildDefaultSounds returns every default sound as configured in a guild
+func GetGuildDefaultSounds(guildID string) ([]*GuildDefaultSound, error) {
+	overrides, err := GetSoundOverrides(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ApplyOverrides(DefaultSounds, overrides), nil
+}
+
+// GetDefaultSoundsByGuild returns the default sounds enabled in a guild, with
+// their weight and commands as configured in the guild
+func GetDefaultSoundsByGuild(guildID string) ([]*Sound, error) {
+	defaults, err := GetGuildDefaultSounds(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sounds []*Sound
+	for _, d := range defaults {
+		if !d.Disabled {
+			sounds = append(sounds, d.Sound)
+		}
+	}
+	return sounds, nil
+}
+
+// GetDefaultSound returns a default sound by name
+func GetDefaultSound(name string) *Sound {
+	for _, s := range DefaultSounds {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
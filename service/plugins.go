@@ -0,0 +1,139 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Redis hash of the plugins loaded by the bot, by name
+const pluginsKey = "airhorn:plugins"
+
+// PluginInfo describes a plugin loaded by the bot
+type PluginInfo struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Description string   `json:"description"`
+	Commands    []string `json:"commands"`
+}
+
+// GuildPlugin is a plugin as configured in a guild
+type GuildPlugin struct {
+	*PluginInfo
+
+	Disabled bool
+}
+
+// PublishPlugins replaces the list of plugins loaded by the bot in redis, so
+// the web app can show them
+func PublishPlugins(pool *redis.Pool, plugins []*PluginInfo) error {
+	conn := pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("DEL", pluginsKey)
+	for _, p := range plugins {
+		b, err := json.Marshal(p)
+		if err != nil {
+			conn.Do("DISCARD")
+			return err
+		}
+		conn.Send("HSET", pluginsKey, p.Name, b)
+	}
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// GetPlugins returns the plugins published by the bot, sorted by name
+func GetPlugins() ([]*PluginInfo, error) {
+	if redisPool == nil {
+		return nil, nil
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", pluginsKey))
+	if err != nil {
+		return nil, err
+	}
+
+	plugins := make([]*PluginInfo, 0, len(values))
+	for _, v := range values {
+		p := &PluginInfo{}
+		if err = json.Unmarshal([]byte(v), p); err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+	return plugins, nil
+}
+
+// GetDisabledPlugins returns the names of the plugins disabled in a guild
+func GetDisabledPlugins(guildID string) (map[string]bool, error) {
+	q := db.Rebind("SELECT pluginName FROM guild_plugin WHERE guildId = ? AND disabled <> 0")
+	rows, err := db.Query(q, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disabled := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		disabled[name] = true
+	}
+	return disabled, rows.Err()
+}
+
+// SetPluginDisabled enables or disables a plugin in a guild
+func SetPluginDisabled(guildID, name string, disabled bool) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	q := tx.Rebind("DELETE FROM guild_plugin WHERE guildId = ? AND pluginName = ?")
+	_, err = tx.Exec(q, guildID, name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if disabled {
+		q = tx.Rebind("INSERT INTO guild_plugin (guildId, pluginName, disabled) VALUES (?, ?, 1)")
+		_, err = tx.Exec(q, guildID, name)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetGuildPlugins returns the plugins loaded by the bot as configured in a guild
+func GetGuildPlugins(guildID string) ([]*GuildPlugin, error) {
+	plugins, err := GetPlugins()
+	if err != nil {
+		return nil, err
+	}
+
+	disabled, err := GetDisabledPlugins(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]*GuildPlugin, len(plugins))
+	for i, p := range plugins {
+		r[i] = &GuildPlugin{PluginInfo: p, Disabled: disabled[p.Name]}
+	}
+	return r, nil
+}
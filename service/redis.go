@@ -27,6 +27,12 @@ func InitRedis(cfg Cfg) bool {
 	return true
 }
 
+// RedisPool returns the pool of redis connections, nil if redis isn't
+// configured
+func RedisPool() *redis.Pool {
+	return redisPool
+}
+
 // CloseRedis closes the redis connection
 func CloseRedis() {
 	if redisPool != nil {
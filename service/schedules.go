@@ -0,0 +1,178 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gitlab.com/Shywim/airhornbot/schedule"
+)
+
+// MaxSchedules is the number of schedules a guild can have
+const MaxSchedules = 25
+
+// One-shot schedules missed by more than this, e.g. while the bot was down,
+// don't play anymore
+const oneShotGrace = time.Minute
+
+// Schedule plays a command of a guild in one of its voice channels, either
+// every time a cron spec matches or once at a given time
+type Schedule struct {
+	ID      string
+	GuildID string
+
+	// Name or ID of the voice channel to play in
+	Channel string
+	Command string
+
+	// Cron spec of a recurring schedule, empty for a one-shot schedule
+	Spec string
+	// Time of a one-shot schedule
+	At time.Time
+
+	// Name of the time zone of the spec
+	Timezone string
+}
+
+// Recurring reports whether the schedule plays every time its spec matches
+func (s *Schedule) Recurring() bool {
+	return s.Spec != ""
+}
+
+// Location returns the time zone of the schedule, UTC if it is unknown
+func (s *Schedule) Location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Validate checks the schedule can be saved
+func (s *Schedule) Validate() error {
+	s.Command = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s.Command), "!"))
+	s.Channel = strings.TrimSpace(s.Channel)
+	s.Spec = strings.TrimSpace(s.Spec)
+
+	if !IsValidCommand(s.Command) || IsReservedCommand(s.Command) {
+		return errors.New("invalid command")
+	}
+	if s.Channel == "" {
+		return errors.New("a voice channel is required")
+	}
+	if s.Timezone == "" {
+		s.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return errors.New("unknown time zone")
+	}
+
+	if s.Recurring() {
+		_, err := schedule.Parse(s.Spec)
+		return err
+	}
+	if s.At.IsZero() {
+		return errors.New("a schedule or a date is required")
+	}
+	if !s.At.After(time.Now()) {
+		return errors.New("the date is in the past")
+	}
+	return nil
+}
+
+// Due reports whether the schedule plays at a minute
+func (s *Schedule) Due(minute time.Time) bool {
+	if !s.Recurring() {
+		return !s.At.After(minute) && !s.Expired(minute)
+	}
+	c, err := schedule.Parse(s.Spec)
+	if err != nil {
+		return false
+	}
+	return c.Match(minute.In(s.Location()))
+}
+
+// Expired reports whether a one-shot schedule was missed at a minute and
+// won't play anymore
+func (s *Schedule) Expired(minute time.Time) bool {
+	return !s.Recurring() && minute.Sub(s.At) > oneShotGrace
+}
+
+// Next returns the next time the schedule plays after t, the zero time if it
+// never will
+func (s *Schedule) Next(t time.Time) time.Time {
+	if !s.Recurring() {
+		if s.At.After(t) {
+			return s.At.In(s.Location())
+		}
+		return time.Time{}
+	}
+	c, err := schedule.Parse(s.Spec)
+	if err != nil {
+		return time.Time{}
+	}
+	return c.Next(t.In(s.Location()))
+}
+
+// NextRun returns the next time the schedule plays, the zero time if it never
+// will
+func (s *Schedule) NextRun() time.Time {
+	return s.Next(time.Now())
+}
+
+// Save adds the schedule to the db
+func (s *Schedule) Save() error {
+	var at int64
+	if !s.Recurring() {
+		at = s.At.Unix()
+	}
+
+	q := db.Rebind("INSERT INTO schedule (guildId, channel, command, spec, at, timezone) VALUES (?, ?, ?, ?, ?, ?)")
+	id, err := insertGetID(db, q, s.GuildID, s.Channel, s.Command, s.Spec, at, s.Timezone)
+	if err != nil {
+		return err
+	}
+	s.ID = id
+	return nil
+}
+
+// DeleteSchedule removes a schedule of a guild
+func DeleteSchedule(guildID, id string) error {
+	q := db.Rebind("DELETE FROM schedule WHERE guildId = ? AND id = ?")
+	_, err := db.Exec(q, guildID, id)
+	return err
+}
+
+// GetSchedules returns the schedules of a guild
+func GetSchedules(guildID string) ([]*Schedule, error) {
+	q := db.Rebind("SELECT id, guildId, channel, command, spec, at, timezone FROM schedule WHERE guildId = ? ORDER BY id")
+	return querySchedules(q, guildID)
+}
+
+// GetAllSchedules returns the schedules of every guild
+func GetAllSchedules() ([]*Schedule, error) {
+	return querySchedules("SELECT id, guildId, channel, command, spec, at, timezone FROM schedule")
+}
+
+func querySchedules(q string, args ...interface{}) ([]*Schedule, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []*Schedule
+	for rows.Next() {
+		s := &Schedule{}
+		var at int64
+		err = rows.Scan(&s.ID, &s.GuildID, &s.Channel, &s.Command, &s.Spec, &at, &s.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		if at != 0 {
+			s.At = time.Unix(at, 0)
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"database/sql"
+)
+
+// Bounds of the master volume of a guild, in percent
+const (
+	DefaultVolume = 100
+	MinVolume     = 10
+	MaxVolume     = 200
+)
+
+// Who can skip, stop and clear the sounds of a guild, server admins always can
+const (
+	ControlAnyone = "anyone"
+	// Users control the sounds they requested only
+	ControlRequester = "requester"
+	// Users with the role set in the settings control every sound
+	ControlRole = "role"
+)
+
+// GuildSettings are the settings of the bot in a guild
+type GuildSettings struct {
+	GuildID string
+
+	// Master volume in percent, applied to every sound
+	Volume int
+
+	// Sounds requested while another plays are mixed with it instead of
+	// being queued
+	Mix bool
+
+	// Who can control the sounds, one of ControlAnyone, ControlRequester or
+	// ControlRole
+	Control string
+	// Name or ID of the role controlling the sounds with ControlRole
+	ControlRole string
+
+	// Users can choose sounds played when they join or leave a voice channel
+	WalkOn bool
+}
+
+// IsValidControl checks a control mode is known
+func IsValidControl(c string) bool {
+	return c == ControlAnyone || c == ControlRequester || c == ControlRole
+}
+
+// GetGuildSettings returns the settings of a guild, or the default settings
+// if the guild never changed them
+func GetGuildSettings(guildID string) (*GuildSettings, error) {
+	s := &GuildSettings{GuildID: guildID, Volume: DefaultVolume, Control: ControlAnyone}
+
+	var mix, walkOn int
+	q := db.Rebind("SELECT volume, mix, control, controlRole, walkOn FROM guild_settings WHERE guildId = ?")
+	err := db.QueryRow(q, guildID).Scan(&s.Volume, &mix, &s.Control, &s.ControlRole, &walkOn)
+	if err == sql.ErrNoRows {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s.Mix = mix != 0
+	s.WalkOn = walkOn != 0
+	if !IsValidControl(s.Control) {
+		s.Control = ControlAnyone
+	}
+	return s, nil
+}
+
+// Save replaces the settings of the guild in the db
+func (s *GuildSettings) Save() error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	q := tx.Rebind("DELETE FROM guild_settings WHERE guildId = ?")
+	_, err = tx.Exec(q, s.GuildID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	mix, walkOn := 0, 0
+	if s.Mix {
+		mix = 1
+	}
+	if s.WalkOn {
+		walkOn = 1
+	}
+	q = tx.Rebind("INSERT INTO guild_settings (guildId, volume, mix, control, controlRole, walkOn) VALUES (?, ?, ?, ?, ?, ?)")
+	_, err = tx.Exec(q, s.GuildID, s.Volume, mix, s.Control, s.ControlRole, walkOn)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetWalkOnGuilds returns the guilds where users can choose their sounds
+func GetWalkOnGuilds() (map[string]bool, error) {
+	rows, err := db.Query("SELECT guildId FROM guild_settings WHERE walkOn = 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	guilds := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		guilds[id] = true
+	}
+	return guilds, rows.Err()
+}
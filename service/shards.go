@@ -0,0 +1,208 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Redis keys of the shards: the shard count every bot process uses and the
+// lease of each shard, by ID
+const (
+	shardCountKey  = "airhorn:shards:count"
+	shardKeyPrefix = "airhorn:shards:"
+)
+
+// ShardLeaseTTL is how long a shard stays claimed by a process which stopped
+// renewing its lease, e.g. because it crashed
+const ShardLeaseTTL = 30 * time.Second
+
+// Errors of the shard leases
+var (
+	ErrShardTaken = errors.New("the shard is run by another process")
+	ErrShardLost  = errors.New("the lease of the shard expired, another process may run it")
+)
+
+// agreeScript stores a shard count unless another count is stored while a
+// shard is claimed, and returns the stored count. The shard keys are built
+// from the prefix since their number depends on the stored count.
+var agreeScript = redis.NewScript(1, `
+local stored = tonumber(redis.call("GET", KEYS[1]))
+if stored and stored ~= tonumber(ARGV[1]) then
+	for i = 0, stored - 1 do
+		if redis.call("EXISTS", ARGV[3] .. i) == 1 then
+			return stored
+		end
+	end
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return tonumber(ARGV[1])`)
+
+// claimScript claims a shard if it is free and the shard count is still the
+// one of the process, it returns -1 if the count changed
+var claimScript = redis.NewScript(2, `
+local stored = tonumber(redis.call("GET", KEYS[2]))
+if stored and stored ~= tonumber(ARGV[3]) then
+	return -1
+end
+if not redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "NX") then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[3], "PX", ARGV[2])
+return 1`)
+
+// renewScript extends a lease and the shard count if the process still holds
+// the lease
+var renewScript = redis.NewScript(2, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[2], ARGV[2])
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseScript removes a lease if the process still holds it
+var releaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Shard count of the bot running in this process without redis
+var localShardCount = 1
+
+// ShardLease is a shard claimed by this process, the other processes can't
+// run it until it is released or its lease expires
+type ShardLease struct {
+	ID    int
+	Count int
+
+	pool  *redis.Pool
+	owner string
+}
+
+// AgreeShardCount returns the shard count of the bot: count if it is set,
+// otherwise the count used by the processes already running, otherwise
+// auto(). It is stored for the other processes and the web app. A count
+// different from the one of the processes running fails, they would serve
+// the same guilds.
+func AgreeShardCount(pool *redis.Pool, count int, auto func() (int, error)) (int, error) {
+	if pool == nil {
+		if count <= 0 {
+			var err error
+			if count, err = auto(); err != nil {
+				return 0, err
+			}
+		}
+		localShardCount = count
+		return count, nil
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	ttl := int64(ShardLeaseTTL / time.Millisecond)
+	if count > 0 {
+		stored, err := redis.Int(agreeScript.Do(conn, shardCountKey, count, ttl, shardKeyPrefix))
+		if err != nil {
+			return 0, err
+		} else if stored != count {
+			return 0, fmt.Errorf("the processes running use %d shards, not %d", stored, count)
+		}
+		return count, nil
+	}
+
+	stored, err := redis.Int(conn.Do("GET", shardCountKey))
+	if err == nil {
+		return stored, nil
+	} else if err != redis.ErrNil {
+		return 0, err
+	}
+
+	if count, err = auto(); err != nil {
+		return 0, err
+	}
+	// another process may have stored its count meanwhile
+	if _, err = conn.Do("SET", shardCountKey, count, "PX", ttl, "NX"); err != nil {
+		return 0, err
+	}
+	return redis.Int(conn.Do("GET", shardCountKey))
+}
+
+// GetShardCount returns the shard count of the bot, for the web app to route
+// its queries to the right process
+func GetShardCount() int {
+	if redisPool == nil {
+		return localShardCount
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	count, err := redis.Int(conn.Do("GET", shardCountKey))
+	if err != nil || count < 1 {
+		return 1
+	}
+	return count
+}
+
+// ClaimShard claims the shard id among count shards, or the first free one if
+// id is negative. It returns ErrShardTaken if it isn't free, and fails if
+// another process changed the shard count since it was agreed on.
+func ClaimShard(pool *redis.Pool, id, count int) (*ShardLease, error) {
+	ids := []int{id}
+	if id < 0 {
+		ids = ids[:0]
+		for i := 0; i < count; i++ {
+			ids = append(ids, i)
+		}
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	owner := uuid.NewV4().String()
+	for _, i := range ids {
+		claimed, err := redis.Int(claimScript.Do(conn, shardKey(i), shardCountKey,
+			owner, int64(ShardLeaseTTL/time.Millisecond), count))
+		if err != nil {
+			return nil, err
+		}
+		switch claimed {
+		case 1:
+			return &ShardLease{ID: i, Count: count, pool: pool, owner: owner}, nil
+		case -1:
+			return nil, fmt.Errorf("another process changed the shard count, it isn't %d anymore", count)
+		}
+	}
+	return nil, ErrShardTaken
+}
+
+// Renew extends the lease, it returns ErrShardLost if it expired before
+func (l *ShardLease) Renew() error {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	renewed, err := redis.Int(renewScript.Do(conn, shardKey(l.ID), shardCountKey,
+		l.owner, int64(ShardLeaseTTL/time.Millisecond)))
+	if err != nil {
+		return err
+	} else if renewed == 0 {
+		return ErrShardLost
+	}
+	return nil
+}
+
+// Release frees the shard for another process
+func (l *ShardLease) Release() error {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	_, err := releaseScript.Do(conn, shardKey(l.ID), l.owner)
+	return err
+}
+
+func shardKey(id int) string {
+	return fmt.Sprintf("%s%d", shardKeyPrefix, id)
+}
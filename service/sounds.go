@@ -2,11 +2,18 @@ package service
 
 import (
 	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
+func init() {
+	rand.Seed(time.Now().UTC().UnixNano())
+}
+
 // Sound represents a sound clip
 type Sound struct {
 	ID      string `json:"id"`
@@ -24,6 +31,13 @@ type Sound struct {
 	CommandsString string
 
 	FilePath string `json:"filepath"`
+
+	// Effects applied by default when the sound is played, see codec.ParseEffects
+	Effects string `json:"effects"`
+
+	// Gain in dB bringing the sound to the loudness of the default sounds,
+	// measured when it is uploaded
+	Gain float64 `json:"gain"`
 }
 
 // Save saves a sound to the db
@@ -35,11 +49,11 @@ func (s *Sound) Save() error {
 	isNew := s.ID == ""
 
 	if isNew {
-		q := tx.Rebind(`INSERT INTO sound (guildID, name, gif, weight, filepath) VALUES (?, ?, ?, ?, ?)`)
-		s.ID, err = insertGetID(tx, q, s.GuildID, s.Name, s.Gif, s.Weight, s.FilePath)
+		q := tx.Rebind(`INSERT INTO sound (guildID, name, gif, weight, filepath, effects, gain) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+		s.ID, err = insertGetID(tx, q, s.GuildID, s.Name, s.Gif, s.Weight, s.FilePath, s.Effects, s.Gain)
 	} else {
-		q := tx.Rebind("UPDATE sound SET name = ?, gif = ?, weight = ? WHERE id = ? AND guildId = ?")
-		_, err = tx.Exec(q, s.Name, s.Gif, s.Weight, s.ID, s.GuildID)
+		q := tx.Rebind("UPDATE sound SET name = ?, gif = ?, weight = ?, effects = ? WHERE id = ? AND guildId = ?")
+		_, err = tx.Exec(q, s.Name, s.Gif, s.Weight, s.Effects, s.ID, s.GuildID)
 	}
 	if err != nil {
 		tx.Rollback()
@@ -83,10 +97,18 @@ func SaveAudio(a io.Reader, n string) error {
 		return err
 	}
 
+	defer out.Close()
+
 	// encore file
-	io.Copy(out, a)
+	_, err = io.Copy(out, a)
+	return err
+}
 
-	return nil
+// SaveGain updates the normalization gain of a sound
+func (s *Sound) SaveGain() error {
+	q := db.Rebind("UPDATE sound SET gain = ? WHERE id = ? AND guildId = ?")
+	_, err := db.Exec(q, s.Gain, s.ID, s.GuildID)
+	return err
 }
 
 // Delete delete a sound from the DB
@@ -119,7 +141,7 @@ func (s *Sound) getCommands() error {
 // GetSound retrieve a sound from database
 func GetSound(ID string) (*Sound, error) {
 	s := Sound{}
-	q := db.Rebind("SELECT * FROM sound WHERE id = ?")
+	q := db.Rebind("SELECT id, guildId AS guildid, name, gif, weight, filepath, effects, gain FROM sound WHERE id = ?")
 	if err := db.QueryRowx(q, ID).StructScan(&s); err != nil {
 		return nil, err
 	}
@@ -139,7 +161,7 @@ func GetSoundsByCommand(command, guildID string) ([]*Sound, error) {
 		return nil, err
 	}
 
-	q = db.Rebind("SELECT id, name, weight, filepath FROM sound WHERE id = ?")
+	q = db.Rebind("SELECT id, name, gif, weight, filepath, effects, gain FROM sound WHERE id = ?")
 	var sounds []*Sound
 	for rows.Next() {
 		var soundID int
@@ -157,7 +179,7 @@ func GetSoundsByCommand(command, guildID string) ([]*Sound, error) {
 
 // GetSoundsByGuild return all sounds for a given Guild
 func GetSoundsByGuild(guildID string) ([]*Sound, error) {
-	q := db.Rebind("SELECT id, name, weight, filepath FROM sound WHERE guildId = ?")
+	q := db.Rebind("SELECT id, name, gif, weight, filepath, effects, gain FROM sound WHERE guildId = ?")
 	rows, err := db.Queryx(q, guildID)
 	if err != nil {
 		return nil, err
@@ -177,6 +199,14 @@ func GetSoundsByGuild(guildID string) ([]*Sound, error) {
 	return sounds, nil
 }
 
+// CountSoundsByGuild returns the number of custom sounds of a guild
+func CountSoundsByGuild(guildID string) (int, error) {
+	var count int
+	q := db.Rebind("SELECT COUNT(*) FROM sound WHERE guildId = ?")
+	err := db.QueryRow(q, guildID).Scan(&count)
+	return count, err
+}
+
 // FilterByCommand filter a sound array by command
 func FilterByCommand(c string, s []*Sound) (r []*Sound) {
 	for _, sound := range s {
@@ -191,162 +221,86 @@ func FilterByCommand(c string, s []*Sound) (r []*Sound) {
 	return r
 }
 
-// DefaultSounds are a set of default sounds available to every servers
-var DefaultSounds = []*Sound{
-	{
-		Name:     "airhorn_default",
-		Weight:   1000,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_default.dca",
-	},
-	{
-		Name:     "airhorn_reverb",
-		Weight:   800,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_reverb.dca",
-	},
-	{
-		Name:     "airhorn_spam",
-		Weight:   800,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_spam.dca",
-	},
-	{
-		Name:     "airhorn_tripletap",
-		Weight:   800,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_tripletap.dca",
-	},
-	{
-		Name:     "airhorn_fourtap",
-		Weight:   800,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_fourtap.dca",
-	},
-	{
-		Name:     "airhorn_distant",
-		Weight:   500,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_distant.dca",
-	},
-	{
-		Name:     "airhorn_echo",
-		Weight:   500,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_echo.dca",
-	},
-	{
-		Name:     "airhorn_clownfull",
-		Weight:   250,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_clownfull.dca",
-	},
-	{
-		Name:     "airhorn_clownshort",
-		Weight:   250,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_clownshort.dca",
-	},
-	{
-		Name:     "airhorn_clownspam",
-		Weight:   250,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_clownspam.dca",
-	},
-	{
-		Name:     "airhorn_highfartlong",
-		Weight:   200,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_highfartlong.dca",
-	},
-	{
-		Name:     "airhorn_highfartshort",
-		Weight:   200,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_highfartshot.dca",
-	},
-	{
-		Name:     "airhorn_midshort",
-		Weight:   100,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_midshort.dca",
-	},
-	{
-		Name:     "airhorn_truck",
-		Weight:   10,
-		Commands: []string{"airhorn"},
-		FilePath: "../audio/airhorn_truck.dca",
-	},
-	{
-		Name:     "another_one",
-		Weight:   1,
-		Commands: []string{"anotha"},
-		FilePath: "../audio/another_one.dca",
-	},
-	{
-		Name:     "another_one_classic",
-		Weight:   1,
-		Commands: []string{"anotha"},
-		FilePath: "../audio/another_one_classic.dca",
-	},
-	{
-		Name:     "another_one_echo",
-		Weight:   1,
-		Commands: []string{"anotha"},
-		FilePath: "../audio/another_one_echo.dca",
-	},
-	{
-		Name:     "jc_realfull",
-		Weight:   1,
-		Commands: []string{"cena"},
-		FilePath: "../audio/jc_realfull.dca",
-	},
-	{
-		Name:     "cow_herd",
-		Weight:   10,
-		Commands: []string{"stan"},
-		FilePath: "../audio/cow_herd.dca",
-	},
-	{
-		Name:     "cow_moo",
-		Weight:   10,
-		Commands: []string{"stan"},
-		FilePath: "../audio/cow_moo.dca",
-	},
-	{
-		Name:     "cow_x3",
-		Weight:   1,
-		Commands: []string{"stan"},
-		FilePath: "../audio/cow_x3.dca",
-	},
-	{
-		Name:     "birthday_horn",
-		Weight:   50,
-		Commands: []string{"bday"},
-		FilePath: "../audio/birthday_horn.dca",
-	},
-	{
-		Name:     "birthday_horn3",
-		Weight:   30,
-		Commands: []string{"bday"},
-		FilePath: "../audio/birthday_horn3.dca",
-	},
-	{
-		Name:     "birthday_sadhorn",
-		Weight:   25,
-		Commands: []string{"bday"},
-		FilePath: "../audio/birthday_sadhorn.dca",
-	},
-	{
-		Name:     "birthday_weakhorn",
-		Weight:   25,
-		Commands: []string{"bday"},
-		FilePath: "../audio/birthday_weakhorn.dca",
-	},
-	{
-		Name:     "wow_thatscool",
-		Weight:   1,
-		Commands: []string{"wtc"},
-		FilePath: "../audio/wow_thatscool.dca",
-	},
+// PickRandom returns a random sound, a sound with a higher weight is more
+// likely to be picked
+func PickRandom(s []*Sound) *Sound {
+	var (
+		i     int
+		total int
+	)
+
+	for _, sound := range s {
+		total += sound.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	number := rand.Intn(total)
+	for _, sound := range s {
+		i += sound.Weight
+
+		if number < i {
+			return sound
+		}
+	}
+	return nil
+}
+
+// CommandPool is the set of sounds a command picks from
+type CommandPool struct {
+	Command string
+	Sounds  []*Sound
+}
+
+// Chance returns the chance in percent that PickRandom picks s in the pool
+func (p *CommandPool) Chance(s *Sound) float64 {
+	total := 0
+	for _, sound := range p.Sounds {
+		total += sound.Weight
+	}
+	if total <= 0 {
+		return 0
+	}
+	return float64(s.Weight) * 100 / float64(total)
+}
+
+// CommandPools groups sounds by command, sorted by command
+func CommandPools(s []*Sound) []*CommandPool {
+	pools := make(map[string]*CommandPool)
+	var commands []string
+	for _, sound := range s {
+		for _, c := range sound.Commands {
+			pool, ok := pools[c]
+			if !ok {
+				pool = &CommandPool{Command: c}
+				pools[c] = pool
+				commands = append(commands, c)
+			}
+			pool.Sounds = append(pool.Sounds, sound)
+		}
+	}
+
+	sort.Strings(commands)
+	r := make([]*CommandPool, len(commands))
+	for i, c := range commands {
+		r[i] = pools[c]
+	}
+	return r
+}
+
+// GetCommandPoolsByGuild returns the command pools of a guild, enabled
+// default sounds included
+func GetCommandPoolsByGuild(guildID string) ([]*CommandPool, error) {
+	defaults, err := GetDefaultSoundsByGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	sounds, err := GetSoundsByGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	return CommandPools(append(defaults, sounds...)), nil
 }
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Kinds of trigger
+const (
+	// A message containing a word or phrase
+	TriggerKeyword = "keyword"
+	// A message matching a regular expression
+	TriggerRegex = "regex"
+	// A reaction with an emoji on a message
+	TriggerReaction = "reaction"
+)
+
+// Limits of the triggers of a guild
+const (
+	MaxTriggers        = 25
+	MaxTriggerPattern  = 100
+	MinTriggerCooldown = 5 * time.Second
+	MaxTriggerCooldown = time.Hour
+)
+
+// Trigger plays a command of a guild when a message or a reaction matches it
+type Trigger struct {
+	ID      string
+	GuildID string
+
+	// One of TriggerKeyword, TriggerRegex or TriggerReaction
+	Kind string
+	// Keyword, regular expression, or emoji (unicode or the name of a custom
+	// emoji) of the trigger
+	Pattern string
+	Command string
+
+	// Minimum time between two plays of the trigger
+	Cooldown time.Duration
+}
+
+// IsValidTriggerKind checks a kind of trigger is known
+func IsValidTriggerKind(k string) bool {
+	return k == TriggerKeyword || k == TriggerRegex || k == TriggerReaction
+}
+
+// Validate checks the trigger can be saved
+func (t *Trigger) Validate() error {
+	t.Command = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t.Command), "!"))
+	t.Pattern = strings.TrimSpace(t.Pattern)
+
+	if !IsValidCommand(t.Command) || IsReservedCommand(t.Command) {
+		return errors.New("invalid command")
+	}
+	if !IsValidTriggerKind(t.Kind) {
+		return errors.New("invalid kind of trigger")
+	}
+	if t.Pattern == "" || utf8.RuneCountInString(t.Pattern) > MaxTriggerPattern {
+		return fmt.Errorf("a keyword, expression or emoji of at most %d characters is required", MaxTriggerPattern)
+	}
+	if t.Cooldown < MinTriggerCooldown || t.Cooldown > MaxTriggerCooldown {
+		return fmt.Errorf("the cooldown must be between %d seconds and an hour", MinTriggerCooldown/time.Second)
+	}
+
+	switch t.Kind {
+	case TriggerKeyword:
+		t.Pattern = strings.ToLower(t.Pattern)
+	case TriggerRegex:
+		if _, err := regexp.Compile(t.Pattern); err != nil {
+			return errors.New("invalid regular expression")
+		}
+	case TriggerReaction:
+		t.Pattern = strings.Trim(t.Pattern, ":")
+	}
+	return nil
+}
+
+// Regexp returns the expression matching the messages of a keyword or regex
+// trigger, keywords match whole words regardless of case
+func (t *Trigger) Regexp() (*regexp.Regexp, error) {
+	if t.Kind == TriggerKeyword {
+		return regexp.Compile(`(?i)(^|\W)` + regexp.QuoteMeta(t.Pattern) + `($|\W)`)
+	}
+	return regexp.Compile(t.Pattern)
+}
+
+// Save adds the trigger to the db
+func (t *Trigger) Save() error {
+	q := db.Rebind("INSERT INTO sound_trigger (guildId, kind, pattern, command, cooldown) VALUES (?, ?, ?, ?, ?)")
+	id, err := insertGetID(db, q, t.GuildID, t.Kind, t.Pattern, t.Command, int64(t.Cooldown/time.Second))
+	if err != nil {
+		return err
+	}
+	t.ID = id
+	return nil
+}
+
+// DeleteTrigger removes a trigger of a guild
+func DeleteTrigger(guildID, id string) error {
+	q := db.Rebind("DELETE FROM sound_trigger WHERE guildId = ? AND id = ?")
+	_, err := db.Exec(q, guildID, id)
+	return err
+}
+
+// GetTriggers returns the triggers of a guild
+func GetTriggers(guildID string) ([]*Trigger, error) {
+	q := db.Rebind("SELECT id, kind, pattern, command, cooldown FROM sound_trigger WHERE guildId = ? ORDER BY id")
+	rows, err := db.Query(q, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var triggers []*Trigger
+	for rows.Next() {
+		t := &Trigger{GuildID: guildID}
+		var cooldown int64
+		if err = rows.Scan(&t.ID, &t.Kind, &t.Pattern, &t.Command, &cooldown); err != nil {
+			return nil, err
+		}
+		t.Cooldown = time.Duration(cooldown) * time.Second
+		triggers = append(triggers, t)
+	}
+	return triggers, rows.Err()
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"database/sql"
+)
+
+// Events a user sound is played on
+const (
+	UserSoundJoin  = "join"
+	UserSoundLeave = "leave"
+)
+
+// UserSound is the sound played when a user joins or leaves a voice channel of
+// a guild, either a custom sound of the guild or a default sound
+type UserSound struct {
+	GuildID string
+	UserID  string
+	Event   string
+
+	// ID of a custom sound of the guild, empty for a default sound
+	SoundID string
+	// Name of a default sound
+	DefaultName string
+}
+
+// GetUserSound returns the sound of a user for an event, nil if they chose
+// none
+func GetUserSound(guildID, userID, event string) (*UserSound, error) {
+	u := &UserSound{GuildID: guildID, UserID: userID, Event: event}
+
+	q := db.Rebind("SELECT soundId, defaultName FROM user_sound WHERE guildId = ? AND userId = ? AND event = ?")
+	err := db.QueryRow(q, guildID, userID, event).Scan(&u.SoundID, &u.DefaultName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// SetUserSound replaces the sound of a user for an event, a sound without
+// SoundID nor DefaultName removes it
+func SetUserSound(u *UserSound) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	q := tx.Rebind("DELETE FROM user_sound WHERE guildId = ? AND userId = ? AND event = ?")
+	_, err = tx.Exec(q, u.GuildID, u.UserID, u.Event)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if u.SoundID != "" || u.DefaultName != "" {
+		q = tx.Rebind("INSERT INTO user_sound (guildId, userId, event, soundId, defaultName) VALUES (?, ?, ?, ?, ?)")
+		_, err = tx.Exec(q, u.GuildID, u.UserID, u.Event, u.SoundID, u.DefaultName)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Sound returns the sound to play, nil if it was deleted or disabled in the
+// guild since it was chosen
+func (u *UserSound) Sound() (*Sound, error) {
+	if u.SoundID != "" {
+		s, err := GetSound(u.SoundID)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if s.GuildID != u.GuildID {
+			return nil, nil
+		}
+		return s, nil
+	}
+
+	defaults, err := GetDefaultSoundsByGuild(u.GuildID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range defaults {
+		if s.Name == u.DefaultName {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"unicode/utf8"
+)
+
+// Limits of the custom sounds of a guild, checked by the dashboard and by the
+// imports
+const (
+	MaxSoundNameLength = 64
+	MaxSoundCommands   = 10
+	MaxGifURLLength    = 255
+	MaxSoundWeight     = 10000
+
+	// Maximum size of the audio file of a sound
+	MaxSoundFileSize = 200000
+
+	// Maximum number of custom sounds in a guild
+	MaxGuildSounds = 200
+)
+
+// ValidateSoundName checks the name of a sound is set and not too long
+func ValidateSoundName(name string) error {
+	if name == "" {
+		return errors.New("a name is required")
+	} else if utf8.RuneCountInString(name) > MaxSoundNameLength {
+		return fmt.Errorf("the name must be at most %d characters long", MaxSoundNameLength)
+	}
+	return nil
+}
+
+// ValidateCommands checks the commands of a sound are valid, not reserved by
+// the bot and listed once
+func ValidateCommands(commands []string) error {
+	if len(commands) == 0 {
+		return errors.New("at least one command is required")
+	}
+	if len(commands) > MaxSoundCommands {
+		return fmt.Errorf("a sound can have at most %d commands", MaxSoundCommands)
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range commands {
+		if !IsValidCommand(c) {
+			return errors.New("commands can only contain lowercase letters, digits, \"-\" and \"_\": " + c)
+		}
+		if IsReservedCommand(c) {
+			return errors.New("this command is used by the bot: " + c)
+		}
+		if seen[c] {
+			return errors.New("this command is listed twice: " + c)
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
+// ValidateWeight checks the weight of a sound is within bounds
+func ValidateWeight(weight int) error {
+	if weight < 1 || weight > MaxSoundWeight {
+		return fmt.Errorf("the weight must be between 1 and %d", MaxSoundWeight)
+	}
+	return nil
+}
+
+// ValidateGif checks the gif of a sound is empty or a http(s) link, the bot
+// posts it as is
+func ValidateGif(s string) error {
+	if s == "" {
+		return nil
+	}
+	if len(s) > MaxGifURLLength {
+		return errors.New("the gif must be a http(s) link")
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("the gif must be a http(s) link")
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package web
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"gitlab.com/Shywim/airhornbot/bus"
+)
+
+// Bus to the bot, in memory until SetBus is called
+var messageBus bus.Bus = bus.NewLocal()
+
+// SetBus sets the bus the dashboard talks to the bot with
+func SetBus(b bus.Bus) {
+	messageBus = b
+}
+
+// publish sends an event to the bot, the bot picks up the change on its own
+// later if it is lost
+func publish(m bus.Message) {
+	err := messageBus.Publish(m)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"topic": m.Topic(),
+			"error": err,
+		}).Warn("Couldn't publish an event to the bot")
+	}
+}
+
+// soundChanged tells the bot sounds of a guild changed
+func soundChanged(guildID, soundID string) {
+	publish(&bus.SoundChanged{GuildID: guildID, SoundID: soundID})
+}
+
+// settingsChanged tells the bot the settings of a guild changed
+func settingsChanged(guildID string) {
+	publish(&bus.SettingsChanged{GuildID: guildID})
+}
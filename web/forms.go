@@ -0,0 +1,433 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/sessions"
+	"gitlab.com/Shywim/airhornbot/codec"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+const (
+	// Name of the form field and header carrying the CSRF token
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+
+	// Maximum length of the name of a plugin
+	maxNameLength = 64
+
+	// Maximum size of a multipart form kept in memory
+	maxFormMemory = 1 << 20
+)
+
+// csrfToken returns the CSRF token tied to the session, creating one if needed
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	session := getSession(r)
+	if token, ok := session.Values["csrf"].(string); ok && token != "" {
+		return token
+	}
+
+	token, err := newCSRFToken(session)
+	if err != nil {
+		return ""
+	}
+	session.Save(r, w)
+	return token
+}
+
+// newCSRFToken stores a new CSRF token in the session, it must be saved after
+func newCSRFToken(s *sessions.Session) (string, error) {
+	token, err := randSeq(32)
+	if err != nil {
+		return "", err
+	}
+	s.Values["csrf"] = token
+	return token, nil
+}
+
+// checkCSRF reports whether the request carries the CSRF token of its session,
+// either in a form field or in a header
+func checkCSRF(r *http.Request) bool {
+	expected, ok := getSession(r).Values["csrf"].(string)
+	if !ok || expected == "" {
+		return false
+	}
+
+	received := r.FormValue(csrfField)
+	if received == "" {
+		received = r.Header.Get(csrfHeader)
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(received)) == 1
+}
+
+// FormErrors maps a form field to its validation error
+type FormErrors map[string]string
+
+// SoundForm holds the values submitted to edit a sound
+type SoundForm struct {
+	ID             string
+	GuildID        string
+	Name           string
+	CommandsString string
+	Commands       []string
+	Gif            string
+	Weight         int
+	Effects        string
+	Errors         FormErrors
+
+	// Total weight of the other sounds sharing a command, used to compute the
+	// chance to play of the sound while editing it
+	OtherWeights map[string]int
+}
+
+// newSoundForm fills a form with the values of an existing sound
+func newSoundForm(s *service.Sound) *SoundForm {
+	return &SoundForm{
+		ID:             s.ID,
+		GuildID:        s.GuildID,
+		Name:           s.Name,
+		CommandsString: s.CommandsString,
+		Commands:       s.Commands,
+		Gif:            s.Gif,
+		Weight:         s.Weight,
+		Effects:        s.Effects,
+		Errors:         FormErrors{},
+	}
+}
+
+// parseSoundForm reads a sound form from a parsed request, missing fields are
+// left empty and reported by Validate
+func parseSoundForm(r *http.Request, guildID, soundID string) *SoundForm {
+	f := &SoundForm{
+		ID:             soundID,
+		GuildID:        guildID,
+		Name:           strings.TrimSpace(r.FormValue("name")),
+		CommandsString: r.FormValue("commands"),
+		Gif:            strings.TrimSpace(r.FormValue("gif")),
+		Effects:        strings.TrimSpace(r.FormValue("effects")),
+		Errors:         FormErrors{},
+	}
+
+	weight, err := strconv.Atoi(strings.TrimSpace(r.FormValue("weight")))
+	if err != nil {
+		f.Errors["weight"] = "The weight must be a number"
+	}
+	f.Weight = weight
+
+	f.Commands = parseCommands(f.CommandsString)
+	return f
+}
+
+// Validate checks every field of the form and returns false if any is invalid.
+// Commands must not be used by the default sounds enabled in the guild.
+func (f *SoundForm) Validate(defaults []*service.Sound) bool {
+	if err := service.ValidateSoundName(f.Name); err != nil {
+		f.Errors["name"] = formError(err)
+	}
+
+	if err := service.ValidateCommands(f.Commands); err != nil {
+		f.Errors["commands"] = formError(err)
+	} else {
+		for _, c := range f.Commands {
+			if len(service.FilterByCommand(c, defaults)) > 0 {
+				f.Errors["commands"] = "This command is already used by a default sound: " + c
+				break
+			}
+		}
+	}
+
+	if err := service.ValidateWeight(f.Weight); err != nil && f.Errors["weight"] == "" {
+		f.Errors["weight"] = formError(err)
+	}
+
+	if err := service.ValidateGif(f.Gif); err != nil {
+		f.Errors["gif"] = formError(err)
+	}
+
+	// effects are stored in their canonical form
+	if effects, err := codec.ParseEffects(f.Effects); err != nil {
+		f.Errors["effects"] = "Invalid effects, " + err.Error()
+	} else {
+		f.Effects = effects.String()
+	}
+
+	return len(f.Errors) == 0
+}
+
+// parseCommands splits a comma separated list of commands, the optional "!"
+// prefix is removed
+func parseCommands(s string) (commands []string) {
+	for _, c := range strings.Split(s, ",") {
+		c = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(c), "!"))
+		if c != "" {
+			commands = append(commands, c)
+		}
+	}
+	return commands
+}
+
+// formError returns the message of a validation error, as displayed under a
+// field
+func formError(err error) string {
+	msg := err.Error()
+	r, size := utf8.DecodeRuneInString(msg)
+	return string(unicode.ToUpper(r)) + msg[size:]
+}
+
+// setOtherWeights fills OtherWeights from the command pools of the guild
+func (f *SoundForm) setOtherWeights(pools []*service.CommandPool) {
+	f.OtherWeights = make(map[string]int)
+	for _, p := range pools {
+		for _, s := range p.Sounds {
+			if s.ID == "" || s.ID != f.ID {
+				f.OtherWeights[p.Command] += s.Weight
+			}
+		}
+	}
+}
+
+// Sound builds the sound described by the form
+func (f *SoundForm) Sound() *service.Sound {
+	return &service.Sound{
+		ID:       f.ID,
+		GuildID:  f.GuildID,
+		Name:     f.Name,
+		Gif:      f.Gif,
+		Weight:   f.Weight,
+		Commands: f.Commands,
+		Effects:  f.Effects,
+	}
+}
+
+// DefaultSoundForm holds the values submitted to customize a default sound
+type DefaultSoundForm struct {
+	GuildID        string
+	Name           string
+	Enabled        bool
+	Weight         int
+	CommandsString string
+	Commands       []string
+	Errors         FormErrors
+
+	// The default sound, as shipped
+	Default *service.Sound
+}
+
+// newDefaultSoundForm fills a form with a default sound as configured in a guild
+func newDefaultSoundForm(guildID string, s *service.GuildDefaultSound, d *service.Sound) *DefaultSoundForm {
+	return &DefaultSoundForm{
+		GuildID:        guildID,
+		Name:           s.Name,
+		Enabled:        !s.Disabled,
+		Weight:         s.Weight,
+		CommandsString: s.CommandsString,
+		Commands:       s.Commands,
+		Errors:         FormErrors{},
+		Default:        d,
+	}
+}
+
+// parseDefaultSoundForm reads a default sound form from a parsed request
+func parseDefaultSoundForm(r *http.Request, guildID string, d *service.Sound) *DefaultSoundForm {
+	f := &DefaultSoundForm{
+		GuildID:        guildID,
+		Name:           d.Name,
+		Enabled:        r.FormValue("enabled") != "",
+		CommandsString: r.FormValue("commands"),
+		Errors:         FormErrors{},
+		Default:        d,
+	}
+
+	weight, err := strconv.Atoi(strings.TrimSpace(r.FormValue("weight")))
+	if err != nil {
+		f.Errors["weight"] = "The weight must be a number"
+	}
+	f.Weight = weight
+	f.Commands = parseCommands(f.CommandsString)
+
+	return f
+}
+
+// Validate checks every field of the form and returns false if any is invalid.
+// Commands must not be used by the custom sounds of the guild, nor by the other
+// default sounds enabled in it unless the sound shipped with them.
+func (f *DefaultSoundForm) Validate(sounds, defaults []*service.Sound) bool {
+	if err := service.ValidateCommands(f.Commands); err != nil {
+		f.Errors["commands"] = formError(err)
+	} else {
+		for _, c := range f.Commands {
+			if len(service.FilterByCommand(c, sounds)) > 0 {
+				f.Errors["commands"] = "This command is already used by a custom sound: " + c
+				break
+			}
+			if f.sharesCommand(c, defaults) {
+				f.Errors["commands"] = "This command is already used by another default sound: " + c
+				break
+			}
+		}
+	}
+	if err := service.ValidateWeight(f.Weight); err != nil && f.Errors["weight"] == "" {
+		f.Errors["weight"] = formError(err)
+	}
+
+	return len(f.Errors) == 0
+}
+
+// sharesCommand reports whether another default sound uses a command the sound
+// didn't ship with, the sounds of a pack share their commands
+func (f *DefaultSoundForm) sharesCommand(c string, defaults []*service.Sound) bool {
+	for _, shipped := range f.Default.Commands {
+		if c == shipped {
+			return false
+		}
+	}
+	for _, d := range service.FilterByCommand(c, defaults) {
+		if d.Name != f.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Override builds the guild override described by the form
+func (f *DefaultSoundForm) Override() *service.SoundOverride {
+	return &service.SoundOverride{
+		GuildID:   f.GuildID,
+		SoundName: f.Name,
+		Disabled:  !f.Enabled,
+		Weight:    f.Weight,
+		Commands:  f.Commands,
+	}
+}
+
+// Prefixes of the values of the sounds a user can choose
+const (
+	customChoicePrefix  = "sound:"
+	defaultChoicePrefix = "default:"
+)
+
+// SoundChoice is a sound of a guild a user can choose, to play when they join
+// or leave a voice channel or from the soundboard
+type SoundChoice struct {
+	Value   string
+	Name    string
+	Default bool
+}
+
+// soundChoices returns the sounds of a guild a user can choose, its custom
+// sounds first
+func soundChoices(guildID string) ([]*SoundChoice, error) {
+	var choices []*SoundChoice
+
+	sounds, err := service.GetSoundsByGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sounds {
+		choices = append(choices, &SoundChoice{Value: customChoicePrefix + s.ID, Name: s.Name})
+	}
+	defaults, err := service.GetDefaultSoundsByGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range defaults {
+		choices = append(choices, &SoundChoice{Value: defaultChoicePrefix + s.Name, Name: s.Name, Default: true})
+	}
+	return choices, nil
+}
+
+// parseSoundChoice returns the ID of the custom sound or the name of the
+// default sound of a choice
+func parseSoundChoice(value string) (soundID, defaultName string) {
+	if strings.HasPrefix(value, customChoicePrefix) {
+		return strings.TrimPrefix(value, customChoicePrefix), ""
+	} else if strings.HasPrefix(value, defaultChoicePrefix) {
+		return "", strings.TrimPrefix(value, defaultChoicePrefix)
+	}
+	return "", ""
+}
+
+// WalkOnForm holds the sounds played when a user joins or leaves a voice
+// channel of a guild
+type WalkOnForm struct {
+	GuildID string
+	UserID  string
+	Join    string
+	Leave   string
+	Errors  FormErrors
+
+	// Sounds which can be chosen
+	Choices []*SoundChoice
+}
+
+// newWalkOnForm fills a form with the sounds chosen by a user in a guild
+func newWalkOnForm(guildID, userID string) (*WalkOnForm, error) {
+	f := &WalkOnForm{GuildID: guildID, UserID: userID, Errors: FormErrors{}}
+
+	var err error
+	f.Choices, err = soundChoices(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range []string{service.UserSoundJoin, service.UserSoundLeave} {
+		u, err := service.GetUserSound(guildID, userID, event)
+		if err != nil {
+			return nil, err
+		}
+		if u == nil {
+			continue
+		}
+
+		value := defaultChoicePrefix + u.DefaultName
+		if u.SoundID != "" {
+			value = customChoicePrefix + u.SoundID
+		}
+		if event == service.UserSoundJoin {
+			f.Join = value
+		} else {
+			f.Leave = value
+		}
+	}
+	return f, nil
+}
+
+// Validate checks the chosen sounds can be chosen and returns false if any
+// can't
+func (f *WalkOnForm) Validate() bool {
+	if f.Join != "" && !f.isChoice(f.Join) {
+		f.Errors["join"] = "This sound doesn't exist anymore"
+	}
+	if f.Leave != "" && !f.isChoice(f.Leave) {
+		f.Errors["leave"] = "This sound doesn't exist anymore"
+	}
+	return len(f.Errors) == 0
+}
+
+func (f *WalkOnForm) isChoice(value string) bool {
+	for _, c := range f.Choices {
+		if c.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// UserSounds builds the user sounds described by the form, a sound without
+// choice removes the user sound of its event
+func (f *WalkOnForm) UserSounds() []*service.UserSound {
+	join := &service.UserSound{GuildID: f.GuildID, UserID: f.UserID, Event: service.UserSoundJoin}
+	leave := &service.UserSound{GuildID: f.GuildID, UserID: f.UserID, Event: service.UserSoundLeave}
+	setSoundChoice(join, f.Join)
+	setSoundChoice(leave, f.Leave)
+	return []*service.UserSound{join, leave}
+}
+
+func setSoundChoice(u *service.UserSound, value string) {
+	u.SoundID, u.DefaultName = parseSoundChoice(value)
+}
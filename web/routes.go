@@ -1,20 +1,38 @@
 package web
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/jonas747/dca"
 	"github.com/julienschmidt/httprouter"
 	uuid "github.com/satori/go.uuid"
+	"gitlab.com/Shywim/airhornbot/bus"
+	"gitlab.com/Shywim/airhornbot/codec"
 	"gitlab.com/Shywim/airhornbot/service"
 	"golang.org/x/oauth2"
 )
 
+// Maximum length of the role controlling the sounds of a guild
+const maxRoleLength = 100
+
+// Maximum length of the channel and the spec of a schedule
+const maxScheduleLength = 100
+
+// Layout of the dates sent by datetime-local inputs
+const scheduleTimeLayout = "2006-01-02T15:04"
+
 // HomeRoute serves home.gohtml
 func HomeRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	tmplCtx := getContext(r)
@@ -33,19 +51,36 @@ func LoginRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	}
 
 	// Create a random state
-	session.Values["state"] = randSeq(32)
-	session.Save(r, w)
+	state, err := randSeq(32)
+	if err != nil {
+		log.WithError(err).Error("Failed to generate OAuth state")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	session.Values["state"] = state
+
+	// Remember where to send the user back after login
+	if next := safeRedirect(r.URL.Query().Get("next")); next != "" {
+		session.Values["next"] = next
+	} else {
+		delete(session.Values, "next")
+	}
 
 	// OR the permissions we want
 	perms := permReadMessages | permSendMessages | permConnect | permSpeak
 
-	noBot := r.URL.Query()["nobot"]
-	if noBot != nil && noBot[0] == "1" {
-		url := manageOAuthConf.AuthCodeURL(session.Values["state"].(string), oauth2.AccessTypeOnline)
+	if r.URL.Query().Get("nobot") == "1" {
+		session.Values["flow"] = flowManage
+		session.Save(r, w)
+
+		url := oauthConf(r, flowManage).AuthCodeURL(state, oauth2.AccessTypeOnline)
 		http.Redirect(w, r, url+fmt.Sprintf("&permissions=%v", perms), http.StatusTemporaryRedirect)
 		return
 	}
 
+	session.Values["flow"] = flowBot
+	session.Save(r, w)
+
 	guildID := r.URL.Query()["guild_id"]
 	var opts []oauth2.AuthCodeOption
 	opts = append(opts, oauth2.AccessTypeOnline)
@@ -54,7 +89,7 @@ func LoginRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		opts = append(opts, guildIDParam)
 	}
 	// Return a redirect to the ouath provider
-	url := botOAuthConf.AuthCodeURL(session.Values["state"].(string), opts...)
+	url := oauthConf(r, flowBot).AuthCodeURL(state, opts...)
 	http.Redirect(w, r, url+fmt.Sprintf("&permissions=%v", perms), http.StatusTemporaryRedirect)
 }
 
@@ -66,25 +101,36 @@ func CallbackRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 		return
 	}
 
-	success := verifyAndOpenSession(w, r, session)
+	flow, _ := session.Values["flow"].(string)
+	next, _ := session.Values["next"].(string)
+
+	success := verifyAndOpenSession(w, r, session, oauthConf(r, flow))
 	if !success {
 		return
 	}
 
-	err := service.AddGuild(r.FormValue("guild_id"))
-	if err != nil {
-		log.WithError(err).Error("Failed to save guild in store")
+	if flow != flowManage && r.FormValue("guild_id") != "" {
+		err := service.AddGuild(r.FormValue("guild_id"))
+		if err != nil {
+			log.WithError(err).Error("Failed to save guild in store")
+		}
 	}
 
-	// And redirect the user back to the dashboard
+	// And redirect the user back to where they came from, or the dashboard
+	if next = safeRedirect(next); next != "" {
+		http.Redirect(w, r, next, http.StatusTemporaryRedirect)
+		return
+	}
 	http.Redirect(w, r, "/?key_to_success=1", http.StatusTemporaryRedirect)
 }
 
-// AskLoginRoute serves login.gohtml
+// AskLoginRoute serves login.gohtml, the user is sent back to the current
+// page once logged in
 func AskLoginRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	tmplCtx := getContext(r)
 	tmplData := TemplateData{
 		Context: tmplCtx,
+		Data:    r.URL.RequestURI(),
 	}
 	renderTemplate(w, "login.gohtml", tmplData)
 }
@@ -138,6 +184,7 @@ func ManageGuildRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Para
 	}
 
 	tmplCtx := getContext(r)
+	tmplCtx.CSRFToken = csrfToken(w, r)
 	tmplData := TemplateData{
 		Context: tmplCtx,
 		Data:    guild,
@@ -145,6 +192,7 @@ func ManageGuildRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Para
 	renderTemplate(w, "guild.gohtml", tmplData)
 }
 
+// EditSoundRoute serves sound.gohtml
 func EditSoundRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var sound *service.Sound
 	soundID := ps.ByName("soundID")
@@ -168,31 +216,55 @@ func EditSoundRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 		sound = &service.Sound{
 			ID:      "new",
 			GuildID: guildID,
+			Weight:  1,
 		}
 	} else {
 		sound, err = service.GetSound(soundID)
-		if err != nil {
+		if err == sql.ErrNoRows || (err == nil && sound.GuildID != guildID) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
 			log.WithFields(log.Fields{
 				"error":   err,
 				"guildID": ps.ByName("guildID"),
 			}).Error("Error retrieving sound")
-			// TODO: error
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		sound.GuildID = guildID
 	}
 
+	renderSoundForm(w, r, newSoundForm(sound))
+}
+
+func renderSoundForm(w http.ResponseWriter, r *http.Request, form *SoundForm) {
+	pools, err := service.GetCommandPoolsByGuild(form.GuildID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"guildID": form.GuildID,
+		}).Warn("Error retrieving guild sounds")
+	}
+	form.setOtherWeights(pools)
+
 	tmplCtx := getContext(r)
+	tmplCtx.CSRFToken = csrfToken(w, r)
 	tmplData := TemplateData{
 		Context: tmplCtx,
-		Data:    sound,
+		Data:    form,
 	}
 	renderTemplate(w, "sound.gohtml", tmplData)
 }
 
+// EditSoundPostRoute validates and saves a sound, the form is displayed again
+// with the errors if any
 func EditSoundPostRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	guildID := ps.ByName("guildID")
+	soundID := ps.ByName("soundID")
 	token := getDiscordToken(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 	session := GetDiscordSession(token)
 
 	hasPerm, err := IsDiscordAdmin(session, guildID)
@@ -209,105 +281,896 @@ func EditSoundPostRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		return
 	}
 
-	r.ParseMultipartForm(0)
+	// the sound edited must belong to the guild managed
+	if soundID != "new" {
+		existing, err := service.GetSound(soundID)
+		if err == sql.ErrNoRows || (err == nil && existing.GuildID != guildID) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	/*weight, err := strconv.Atoi(r.MultipartForm.Value["weight"][0])
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	err = r.ParseMultipartForm(maxFormMemory)
+	if err != nil && err != http.ErrNotMultipart {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
 		return
-	}*/
-	weight := 1
+	}
 
-	commands := r.MultipartForm.Value["commands"][0]
-	if commands == "" {
-		http.Error(w, err.Error(), http.StatusNotAcceptable)
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
 		return
 	}
 
-	soundID := ps.ByName("soundID")
+	defaults, err := service.GetDefaultSoundsByGuild(guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	form := parseSoundForm(r, guildID, soundID)
+	form.Validate(defaults)
+
+	var sndFile multipart.File
+	var sndFileH *multipart.FileHeader
 	if soundID == "new" {
-		sndFile, sndFileH, err := r.FormFile("file")
+		sndFile, sndFileH, err = r.FormFile("file")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		soundID = uuid.NewV4().String()
-		// read file size
-		var sndFileSize int64
-		switch t := sndFile.(type) {
-		case *os.File:
-			sndFileInfo, err := t.Stat()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			sndFileSize = sndFileInfo.Size()
-			break
-		default:
-			sndFileSize, err = sndFile.Seek(0, 0)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+			form.Errors["file"] = "A sound file is required"
+		} else {
+			defer sndFile.Close()
+			// check file > 200kB
+			if sndFileH.Size > service.MaxSoundFileSize {
+				form.Errors["file"] = "File too large"
 			}
-			break
 		}
 
-		// check file > 200kB
-		if sndFileSize > 200000 {
-			http.Error(w, "File too large", http.StatusNotAcceptable)
+		count, err := service.CountSoundsByGuild(guildID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if count >= service.MaxGuildSounds {
+			form.Errors["file"] = fmt.Sprintf("This server already has the maximum of %d sounds", service.MaxGuildSounds)
+		}
+	}
+
+	if len(form.Errors) > 0 {
+		renderSoundForm(w, r, form)
+		return
+	}
+
+	sound := form.Sound()
+	if soundID == "new" {
+		sound.ID = ""
+		sound.FilePath = uuid.NewV4().String()
 
 		var dcaData io.Reader
 		if !strings.HasSuffix(sndFileH.Filename, ".dca") {
 			// convert file if (presumably) not a dca file
 			dcaSession, err := dca.EncodeMem(sndFile, dca.StdEncodeOptions)
-			defer dcaSession.Cleanup()
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			defer dcaSession.Cleanup()
 			dcaData = dcaSession
 		} else {
 			dcaData = sndFile
 		}
 
-		err = service.SaveAudio(dcaData, soundID)
+		err = service.SaveAudio(dcaData, sound.FilePath)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		sound := service.Sound{
-			Name:     r.MultipartForm.Value["name"][0],
-			Weight:   weight,
-			FilePath: soundID,
-			GuildID:  guildID,
-			Commands: strings.Split(commands, ","),
+		_, err = service.MeasureGain(sound)
+		if err != nil {
+			// the sound is still playable, only not normalized
+			log.WithFields(log.Fields{
+				"sound": sound.Name,
+				"error": err,
+			}).Warn("Couldn't measure the loudness of a sound")
 		}
+	}
+
+	err = sound.Save()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	soundChanged(guildID, sound.ID)
+
+	http.Redirect(w, r, "/manage/"+guildID, http.StatusSeeOther)
+}
+
+// Cache-Control of the audio of default and custom sounds
+const (
+	defaultAudioCacheControl = "public, max-age=86400"
+	customAudioCacheControl  = "private, max-age=3600"
+)
+
+// DefaultSoundAudioRoute streams a default sound as Ogg Opus
+func DefaultSoundAudioRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sound := service.GetDefaultSound(ps.ByName("name"))
+	if sound == nil {
+		http.NotFound(w, r)
+		return
+	}
+	serveSoundAudio(w, r, sound, defaultAudioCacheControl)
+}
 
-		err = sound.Save()
+// SoundAudioRoute streams a custom sound of a guild as Ogg Opus, to the
+// members of the guild
+func SoundAudioRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	userID, err := memberUser(r, guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sound, err := service.GetSound(ps.ByName("soundID"))
+	if err == sql.ErrNoRows || (err == nil && sound.GuildID != guildID) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveSoundAudio(w, r, sound, customAudioCacheControl)
+}
+
+// serveSoundAudio remuxes the opus frames of a sound in an Ogg container
+// browsers can play, with its normalization gain. Its effects aren't applied.
+func serveSoundAudio(w http.ResponseWriter, r *http.Request, sound *service.Sound, cacheControl string) {
+	path := service.AudioPath(sound)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	ogg, err := codec.NewOggWriter(buf, crc32.ChecksumIEEE([]byte(path)), sound.Gain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	decoder := dca.NewDecoder(file)
+	for {
+		frame, err := decoder.OpusFrame()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err == nil {
+			err = ogg.WriteFrame(frame)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"sound": sound.Name,
+				"error": err,
+			}).Error("Couldn't remux sound")
+			http.Error(w, "Invalid sound file", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err = ogg.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the gain changes when the sound is normalized again
+	etag := fmt.Sprintf(`"%x-%x-%.1f"`, stat.ModTime().Unix(), stat.Size(), sound.Gain)
+	w.Header().Set("Content-Type", "audio/ogg")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", stat.ModTime(), bytes.NewReader(buf.Bytes()))
+}
+
+// PreviewCommandRoute picks a sound for a command the same way the bot does,
+// without playing it
+func PreviewCommandRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	command := ps.ByName("command")
+
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
+
+	pools, err := service.GetCommandPoolsByGuild(guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, p := range pools {
+		if p.Command != command {
+			continue
+		}
+
+		sound := service.PickRandom(p.Sounds)
+		if sound == nil {
+			break
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"command": command,
+			"sound":   sound.Name,
+			"chance":  p.Chance(sound),
+		})
+		return
+	}
+
+	http.Error(w, "Unknown command", http.StatusNotFound)
+}
+
+// checkGuildAdmin verifies the logged in user is an admin of the guild, an
+// error is written to the response if not
+func checkGuildAdmin(w http.ResponseWriter, r *http.Request, guildID string) bool {
+	token := getDiscordToken(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	isAdmin, err := IsDiscordAdmin(GetDiscordSession(token), guildID)
+	if err != nil || !isAdmin {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// EditDefaultSoundRoute serves default.gohtml
+func EditDefaultSoundRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	name := ps.ByName("name")
+
+	token := getDiscordToken(r)
+	if token == "" {
+		AskLoginRoute(w, r, nil)
+		return
+	}
+
+	isAdmin, err := IsDiscordAdmin(GetDiscordSession(token), guildID)
+	if err != nil || !isAdmin {
+		AskLoginRoute(w, r, nil)
+		return
+	}
+
+	defaults, err := service.GetGuildDefaultSounds(guildID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"guildID": guildID,
+		}).Error("Error retrieving default sounds")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	for _, d := range defaults {
+		if d.Name == name {
+			renderDefaultSoundForm(w, r, newDefaultSoundForm(guildID, d, service.GetDefaultSound(name)))
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func renderDefaultSoundForm(w http.ResponseWriter, r *http.Request, form *DefaultSoundForm) {
+	tmplCtx := getContext(r)
+	tmplCtx.CSRFToken = csrfToken(w, r)
+	tmplData := TemplateData{
+		Context: tmplCtx,
+		Data:    form,
+	}
+	renderTemplate(w, "default.gohtml", tmplData)
+}
+
+// EditDefaultSoundPostRoute saves or resets the override of a default sound
+func EditDefaultSoundPostRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	d := service.GetDefaultSound(ps.ByName("name"))
+	if d == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.FormValue("reset") != "" {
+		err := service.DeleteSoundOverride(guildID, d.Name)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-	} else {
-		sound := service.Sound{
-			ID:       soundID,
-			Name:     r.MultipartForm.Value["name"][0],
-			Weight:   weight,
-			FilePath: soundID,
-			GuildID:  guildID,
-			Commands: strings.Split(commands, ","),
+		settingsChanged(guildID)
+		http.Redirect(w, r, "/manage/"+guildID, http.StatusSeeOther)
+		return
+	}
+
+	sounds, err := service.GetSoundsByGuild(guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defaults, err := service.GetDefaultSoundsByGuild(guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	form := parseDefaultSoundForm(r, guildID, d)
+	if !form.Validate(sounds, defaults) {
+		renderDefaultSoundForm(w, r, form)
+		return
+	}
+
+	err = form.Override().Save()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	settingsChanged(guildID)
+
+	http.Redirect(w, r, "/manage/"+guildID, http.StatusSeeOther)
+}
+
+// ExportSoundsRoute sends an archive of the custom sounds of a guild
+func ExportSoundsRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"airhorn-%s.zip\"", guildID))
+	err := service.ExportGuildSounds(guildID, w)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"guildID": guildID,
+		}).Error("Error exporting guild sounds")
+	}
+}
+
+// ImportSoundsRoute imports an archive of sounds in a guild and serves
+// import.gohtml with the result
+func ImportSoundsRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
+
+	// the form holds the archive and a few small fields
+	r.Body = http.MaxBytesReader(w, r.Body, service.MaxArchiveSize+maxFormMemory)
+	err := r.ParseMultipartForm(maxFormMemory)
+	if err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	mode, err := service.ParseConflictMode(r.FormValue("conflict"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	archive, archiveH, err := r.FormFile("archive")
+	if err != nil {
+		http.Error(w, "An archive is required", http.StatusBadRequest)
+		return
+	}
+	defer archive.Close()
+
+	report, err := service.ImportGuildSounds(guildID, archive, archiveH.Size, mode)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"guildID": guildID,
+		}).Warn("Error importing guild sounds")
+		if report == nil {
+			report = &service.ImportReport{}
 		}
+		report.Errors = append(report.Errors, err.Error())
+	}
+	soundChanged(guildID, "")
+
+	tmplCtx := getContext(r)
+	tmplData := TemplateData{
+		Context: tmplCtx,
+		Data: struct {
+			GuildID string
+			Report  *service.ImportReport
+		}{guildID, report},
+	}
+	renderTemplate(w, "import.gohtml", tmplData)
+}
+
+// TogglePluginRoute enables or disables a plugin in a guild
+func TogglePluginRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	name := ps.ByName("name")
+	if name == "" || len(name) > maxNameLength {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := service.SetPluginDisabled(guildID, name, r.FormValue("enabled") == "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	settingsChanged(guildID)
+
+	http.Redirect(w, r, "/manage/"+guildID, http.StatusSeeOther)
+}
+
+// GuildSettingsRoute saves the settings of the bot in a guild
+func GuildSettingsRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
 
-		err = sound.Save()
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	volume, err := strconv.Atoi(strings.TrimSpace(r.FormValue("volume")))
+	if err != nil || volume < service.MinVolume || volume > service.MaxVolume {
+		http.Error(w, fmt.Sprintf("The volume must be a number between %d and %d",
+			service.MinVolume, service.MaxVolume), http.StatusBadRequest)
+		return
+	}
+
+	control := r.FormValue("control")
+	if !service.IsValidControl(control) {
+		http.Error(w, "Invalid control mode", http.StatusBadRequest)
+		return
+	}
+	role := strings.TrimSpace(r.FormValue("control_role"))
+	if len(role) > maxRoleLength || (control == service.ControlRole && role == "") {
+		http.Error(w, "A role is required to control the sounds", http.StatusBadRequest)
+		return
+	}
+
+	settings := &service.GuildSettings{
+		GuildID:     guildID,
+		Volume:      volume,
+		Mix:         r.FormValue("mix") != "",
+		WalkOn:      r.FormValue("walk_on") != "",
+		Control:     control,
+		ControlRole: role,
+	}
+	if err = settings.Save(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	settingsChanged(guildID)
+
+	http.Redirect(w, r, "/manage/"+guildID, http.StatusSeeOther)
+}
+
+// AddScheduleRoute adds a schedule to a guild
+func AddScheduleRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	s := &service.Schedule{
+		GuildID:  guildID,
+		Channel:  r.FormValue("channel"),
+		Command:  r.FormValue("command"),
+		Spec:     r.FormValue("spec"),
+		Timezone: strings.TrimSpace(r.FormValue("timezone")),
+	}
+	if len(s.Channel) > maxScheduleLength || len(s.Spec) > maxScheduleLength {
+		http.Error(w, "Invalid schedule", http.StatusBadRequest)
+		return
+	}
+	if at := strings.TrimSpace(r.FormValue("at")); s.Spec == "" && at != "" {
+		loc, err := time.LoadLocation(s.Timezone)
 		if err != nil {
+			http.Error(w, "Unknown time zone", http.StatusBadRequest)
+			return
+		}
+		s.At, err = time.ParseInLocation(scheduleTimeLayout, at, loc)
+		if err != nil {
+			http.Error(w, "Invalid date", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := s.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedules, err := service.GetSchedules(guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(schedules) >= service.MaxSchedules {
+		http.Error(w, fmt.Sprintf("A server can have %d schedules", service.MaxSchedules), http.StatusBadRequest)
+		return
+	}
+
+	if err = s.Save(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	settingsChanged(guildID)
+
+	http.Redirect(w, r, "/manage/"+guildID, http.StatusSeeOther)
+}
+
+// DeleteScheduleRoute removes a schedule of a guild
+func DeleteScheduleRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	err := service.DeleteSchedule(guildID, ps.ByName("scheduleID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	settingsChanged(guildID)
+
+	http.Redirect(w, r, "/manage/"+guildID, http.StatusSeeOther)
+}
+
+// AddTriggerRoute adds a trigger to a guild
+func AddTriggerRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	cooldown, err := strconv.Atoi(strings.TrimSpace(r.FormValue("cooldown")))
+	if err != nil {
+		http.Error(w, "The cooldown must be a number of seconds", http.StatusBadRequest)
+		return
+	}
+
+	t := &service.Trigger{
+		GuildID:  guildID,
+		Kind:     r.FormValue("kind"),
+		Pattern:  r.FormValue("pattern"),
+		Command:  r.FormValue("command"),
+		Cooldown: time.Duration(cooldown) * time.Second,
+	}
+	if err = t.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	triggers, err := service.GetTriggers(guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(triggers) >= service.MaxTriggers {
+		http.Error(w, fmt.Sprintf("A server can have %d triggers", service.MaxTriggers), http.StatusBadRequest)
+		return
+	}
+
+	if err = t.Save(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	settingsChanged(guildID)
+
+	http.Redirect(w, r, "/manage/"+guildID, http.StatusSeeOther)
+}
+
+// DeleteTriggerRoute removes a trigger of a guild
+func DeleteTriggerRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	err := service.DeleteTrigger(guildID, ps.ByName("triggerID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	settingsChanged(guildID)
+
+	http.Redirect(w, r, "/manage/"+guildID, http.StatusSeeOther)
+}
+
+// walkOnUser returns the ID of the logged in user if they are a member of a
+// guild where users choose their join and leave sounds, empty otherwise
+func walkOnUser(r *http.Request, guildID string) (string, error) {
+	settings, err := service.GetGuildSettings(guildID)
+	if err != nil || !settings.WalkOn {
+		return "", err
+	}
+	return memberUser(r, guildID)
+}
+
+// memberUser returns the ID of the logged in user if they are a member of a
+// guild, empty otherwise
+func memberUser(r *http.Request, guildID string) (string, error) {
+	token := getDiscordToken(r)
+	if token == "" {
+		return "", nil
+	}
+	session := GetDiscordSession(token)
+	if session == nil {
+		return "", nil
+	}
+
+	isMember, err := IsDiscordMember(session, guildID)
+	if err != nil || !isMember {
+		return "", err
+	}
+
+	user, err := session.User("@me")
+	if err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
+
+// WalkOnRoute serves walkon.gohtml, where users choose the sounds played when
+// they join or leave a voice channel
+func WalkOnRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if getDiscordToken(r) == "" {
+		AskLoginRoute(w, r, nil)
+		return
+	}
+
+	userID, err := walkOnUser(r, guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	form, err := newWalkOnForm(guildID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderWalkOnForm(w, r, form)
+}
+
+func renderWalkOnForm(w http.ResponseWriter, r *http.Request, form *WalkOnForm) {
+	tmplCtx := getContext(r)
+	tmplCtx.CSRFToken = csrfToken(w, r)
+	tmplData := TemplateData{
+		Context: tmplCtx,
+		Data:    form,
+	}
+	renderTemplate(w, "walkon.gohtml", tmplData)
+}
+
+// WalkOnPostRoute saves the join and leave sounds of a user, the form is
+// displayed again with the errors if any
+func WalkOnPostRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	userID, err := walkOnUser(r, guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	form, err := newWalkOnForm(guildID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	form.Join = r.FormValue("join")
+	form.Leave = r.FormValue("leave")
+	if !form.Validate() {
+		renderWalkOnForm(w, r, form)
+		return
+	}
+
+	for _, u := range form.UserSounds() {
+		if err = service.SetUserSound(u); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 
-	ManageGuildRoute(w, r, ps)
+	http.Redirect(w, r, "/walkon/"+guildID, http.StatusSeeOther)
+}
+
+// Time the soundboard waits for the bot to answer
+const boardTimeout = 5 * time.Second
+
+// BoardRoute serves board.gohtml, a soundboard playing the sounds of a guild
+// in the voice channel of the user
+func BoardRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if getDiscordToken(r) == "" {
+		AskLoginRoute(w, r, nil)
+		return
+	}
+
+	userID, err := memberUser(r, guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	choices, err := soundChoices(guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tmplCtx := getContext(r)
+	tmplCtx.CSRFToken = csrfToken(w, r)
+	tmplData := TemplateData{
+		Context: tmplCtx,
+		Data: struct {
+			GuildID string
+			Sounds  []*SoundChoice
+		}{guildID, choices},
+	}
+	renderTemplate(w, "board.gohtml", tmplData)
+}
+
+// BoardPlayRoute asks the bot to play a sound of the soundboard and answers
+// with its result in JSON
+func BoardPlayRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	userID, err := memberUser(r, guildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	q := &bus.PlaySoundQuery{GuildID: guildID, UserID: userID}
+	q.SoundID, q.DefaultName = parseSoundChoice(r.FormValue("sound"))
+	if q.SoundID == "" && q.DefaultName == "" {
+		http.Error(w, "Unknown sound", http.StatusBadRequest)
+		return
+	}
+
+	// only the process running the shard of the guild can play in it
+	res := &bus.PlaySoundResult{}
+	err = messageBus.Request(bus.Route(q, guildID, service.GetShardCount()), res, boardTimeout)
+	switch err {
+	case nil:
+	case bus.ErrNoHandler, bus.ErrTimeout:
+		res = &bus.PlaySoundResult{Message: "Couldn't reach the bot, is it online?"}
+	default:
+		log.WithError(err).Error("Soundboard request failed")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// Time the dashboard waits for the status of the bot
+const statusTimeout = 2 * time.Second
+
+// GuildStatusRoute answers in JSON the voice channel of the bot in a guild and
+// what it plays
+func GuildStatusRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	guildID := ps.ByName("guildID")
+	if !checkGuildAdmin(w, r, guildID) {
+		return
+	}
+
+	status := struct {
+		Online bool             `json:"online"`
+		Voice  *bus.VoiceStatus `json:"voice"`
+		Player *bus.NowPlaying  `json:"player"`
+	}{Voice: &bus.VoiceStatus{}, Player: &bus.NowPlaying{Queue: []string{}}}
+
+	shards := service.GetShardCount()
+	err := messageBus.Request(bus.Route(&bus.VoiceStatusQuery{GuildID: guildID}, guildID, shards), status.Voice, statusTimeout)
+	if err == nil {
+		err = messageBus.Request(bus.Route(&bus.NowPlayingQuery{GuildID: guildID}, guildID, shards), status.Player, statusTimeout)
+	}
+	switch err {
+	case nil:
+		status.Online = true
+	case bus.ErrNoHandler, bus.ErrTimeout:
+	default:
+		log.WithError(err).Error("Status request failed")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
 }
@@ -0,0 +1,194 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/antage/eventsource"
+	"github.com/gorilla/handlers"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/Shywim/airhornbot/service"
+)
+
+// Time between two pushes of the stats to the browsers
+const statsInterval = 5 * time.Second
+
+// Server serves the dashboard
+type Server struct {
+	http *http.Server
+
+	// Used for pushing live stat updates to the client, nil without redis
+	es   eventsource.EventSource
+	stop chan struct{}
+}
+
+// NewServer loads the templates and sets up the sessions of the dashboard,
+// it listens on the port in the PORT environment variable, 14000 by default.
+// Stats are pushed live to the browsers when redis is set up.
+func NewServer(cfg service.Cfg) *Server {
+	LoadTemplates("templates")
+	InitSessions(cfg)
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "14000"
+	}
+
+	s := &Server{
+		http: &http.Server{Addr: ":" + port},
+		stop: make(chan struct{}),
+	}
+	if service.RedisPool() != nil {
+		s.es = eventsource.New(nil, func(req *http.Request) [][]byte {
+			return [][]byte{
+				[]byte("X-Accel-Buffering: no"),
+				[]byte("Access-Control-Allow-Origin: *"),
+			}
+		},
+		)
+	}
+	return s
+}
+
+func (s *Server) router() http.Handler {
+	server := httprouter.New()
+	server.GET("/", HomeRoute)
+	server.GET("/login", LoginRoute)
+	server.GET("/callback", CallbackRoute)
+	server.GET("/manage", ManageRoute)
+	server.GET("/manage/:guildID/sound/:soundID", EditSoundRoute)
+	server.POST("/manage/:guildID/sound/:soundID", EditSoundPostRoute)
+	server.GET("/manage/:guildID/sound/:soundID/audio", SoundAudioRoute)
+	server.GET("/manage/:guildID", ManageGuildRoute)
+	server.GET("/manage/:guildID/preview/:command", PreviewCommandRoute)
+	server.GET("/manage/:guildID/default/:name", EditDefaultSoundRoute)
+	server.POST("/manage/:guildID/default/:name", EditDefaultSoundPostRoute)
+	server.GET("/manage/:guildID/status", GuildStatusRoute)
+	server.GET("/manage/:guildID/export", ExportSoundsRoute)
+	server.POST("/manage/:guildID/import", ImportSoundsRoute)
+	server.POST("/manage/:guildID/plugin/:name", TogglePluginRoute)
+	server.POST("/manage/:guildID/settings", GuildSettingsRoute)
+	server.POST("/manage/:guildID/schedule", AddScheduleRoute)
+	server.POST("/manage/:guildID/schedule/:scheduleID/delete", DeleteScheduleRoute)
+	server.POST("/manage/:guildID/trigger", AddTriggerRoute)
+	server.POST("/manage/:guildID/trigger/:triggerID/delete", DeleteTriggerRoute)
+	server.GET("/walkon/:guildID", WalkOnRoute)
+	server.POST("/walkon/:guildID", WalkOnPostRoute)
+	server.GET("/audio/default/:name", DefaultSoundAudioRoute)
+	server.GET("/guild/:guildID/board", BoardRoute)
+	server.POST("/guild/:guildID/board/play", BoardPlayRoute)
+	server.DELETE("/manage/:guildId/:soundId", handleDeleteSound)
+
+	// Only add this route if we have stats to push (e.g. redis connection)
+	if s.es != nil {
+		server.Handler("GET", "/events", s.es)
+	}
+
+	server.NotFound = defaultHandler
+	return server
+}
+
+// ListenAndServe serves the dashboard until Shutdown is called, it then
+// returns http.ErrServerClosed
+func (s *Server) ListenAndServe() error {
+	log.WithFields(log.Fields{
+		"port": strings.TrimPrefix(s.http.Addr, ":"),
+	}).Info("Starting HTTP Server")
+
+	// If the requests log doesnt exist, make it
+	if _, err := os.Stat("requests.log"); os.IsNotExist(err) {
+		ioutil.WriteFile("requests.log", []byte{}, 0600)
+	}
+
+	// Open the log file in append mode
+	logFile, err := os.OpenFile("requests.log", os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to open requests log file")
+		return err
+	}
+	defer logFile.Close()
+
+	if s.es != nil {
+		go s.broadcastLoop()
+	}
+
+	// Actually start the server
+	s.http.Handler = handlers.LoggingHandler(logFile, s.router())
+	return s.http.ListenAndServe()
+}
+
+// Shutdown stops accepting requests and waits for the ones being served until
+// ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.stop)
+	if s.es != nil {
+		defer s.es.Close()
+	}
+	return s.http.Shutdown(ctx)
+}
+
+// broadcastLoop pushes the stats to the browsers until the server shuts down
+func (s *Server) broadcastLoop() {
+	var id int
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(statsInterval):
+		}
+
+		s.es.SendEventMessage(string(service.GetStats().ToJSON()), "message", strconv.Itoa(id))
+		id++
+	}
+}
+
+func handleDeleteSound(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	/*	guildID := ps.ByName("guildId")
+		soundID := ps.ByName("soundId")
+		session, _ := store.Get(r, "session")
+		token := session.Values["token"]
+
+		isAdmin, err := checkIsGuildAdmin(guildID, string(token.(string)))
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "HTTP 401") {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if isAdmin == false {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		err = service.DeleteSound(guildID, soundID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+	*/
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func defaultHandler(w http.ResponseWriter, r *http.Request) {
+	fileServer := http.FileServer(http.Dir("public"))
+
+	// golang use the old "application/x-javascript" by default, we override that
+	if strings.HasSuffix(r.URL.String(), ".js") {
+		w.Header().Set("Content-Type", "application/javascript")
+	}
+
+	// let FileServer handle the end of the response
+	fileServer.ServeHTTP(w, r)
+}
@@ -2,11 +2,14 @@ package web
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -37,9 +40,18 @@ var (
 	// Oauth2 config for managing bot
 	manageOAuthConf *oauth2.Config
 
+	// Public URLs the dashboard is served at, the first one is the default
+	siteURLs []string
+
 	userAudioPath *string
 )
 
+// OAuth flows, stored in the session to pick the right config on callback
+const (
+	flowBot    = "bot"
+	flowManage = "manage"
+)
+
 func InitSessions(cfg service.Cfg) {
 	userAudioPath = &cfg.DataPath
 	store = sessions.NewCookieStore([]byte(cfg.DiscordClientSecret))
@@ -55,7 +67,6 @@ func InitSessions(cfg service.Cfg) {
 		ClientSecret: cfg.DiscordClientSecret,
 		Scopes:       []string{"bot", "identify", "guilds"},
 		Endpoint:     endpoint,
-		RedirectURL:  "http://airhorn.shywim.fr/callback",
 	}
 
 	manageOAuthConf = &oauth2.Config{
@@ -63,19 +74,56 @@ func InitSessions(cfg service.Cfg) {
 		ClientSecret: cfg.DiscordClientSecret,
 		Scopes:       []string{"identify", "guilds"},
 		Endpoint:     endpoint,
-		RedirectURL:  "http://airhorn.shywim.fr/callback",
 	}
+
+	siteURLs = append([]string{cfg.BaseURL}, cfg.AltURLs...)
+}
+
+// Return a random url-safe sequence built from n bytes of crypto/rand
+func randSeq(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// siteURL returns the configured public URL matching the request host,
+// or the default one if the host is unknown
+func siteURL(r *http.Request) string {
+	for _, s := range siteURLs {
+		u, err := url.Parse(s)
+		if err == nil && strings.EqualFold(u.Host, r.Host) {
+			return s
+		}
+	}
+	return siteURLs[0]
+}
+
+// oauthConf returns the OAuth2 config for a flow, redirecting to the host
+// the request was made on
+func oauthConf(r *http.Request, flow string) *oauth2.Config {
+	conf := *botOAuthConf
+	if flow == flowManage {
+		conf = *manageOAuthConf
+	}
+	conf.RedirectURL = siteURL(r) + "/callback"
+	return &conf
 }
 
-var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+// safeRedirect returns next if it is a local path, or an empty string.
+// This prevents the "next" parameter from sending users to another site.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") ||
+		strings.Contains(next, "\\") {
+		return ""
+	}
 
-// Return a random character sequence of n length
-func randSeq(n int) string {
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return ""
 	}
-	return string(b)
+	return u.RequestURI()
 }
 
 func getSession(r *http.Request) *sessions.Session {
@@ -114,14 +162,29 @@ func IsDiscordAdmin(session *discordgo.Session, guildID string) (bool, error) {
 	}
 
 	for _, g := range userGuilds {
-		if g.Permissions&permAdministrator != 0 {
+		if g.ID == guildID && g.Permissions&permAdministrator != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// IsDiscordMember checks the user of a session is a member of a guild
+func IsDiscordMember(session *discordgo.Session, guildID string) (bool, error) {
+	userGuilds, err := session.UserGuilds(100, "", "")
+	if err != nil {
+		return false, err
+	}
+
+	for _, g := range userGuilds {
+		if g.ID == guildID {
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
-func verifyAndOpenSession(w http.ResponseWriter, r *http.Request, s *sessions.Session) bool {
+func verifyAndOpenSession(w http.ResponseWriter, r *http.Request, s *sessions.Session, conf *oauth2.Config) bool {
 	// Check the state string is correct
 	state := r.FormValue("state")
 	if state != s.Values["state"] {
@@ -142,7 +205,7 @@ func verifyAndOpenSession(w http.ResponseWriter, r *http.Request, s *sessions.Se
 		return false
 	}
 
-	token, err := botOAuthConf.Exchange(oauth2.NoContext, r.FormValue("code"))
+	token, err := conf.Exchange(oauth2.NoContext, r.FormValue("code"))
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -202,7 +265,10 @@ func verifyAndOpenSession(w http.ResponseWriter, r *http.Request, s *sessions.Se
 	s.Values["token"] = token.AccessToken
 	s.Values["username"] = user.Username
 	s.Values["tag"] = user.Discriminator
+	newCSRFToken(s)
 	delete(s.Values, "state")
+	delete(s.Values, "flow")
+	delete(s.Values, "next")
 	s.Save(r, w)
 
 	return true
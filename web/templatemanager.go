@@ -25,11 +25,12 @@ type TemplateContext struct {
 	NoRedis      bool
 	SiteURL      string
 	StatsCounter service.CountUpdate
+	CSRFToken    string
 }
 
 func getContext(r *http.Request) TemplateContext {
 	return TemplateContext{
-		SiteURL:      "//" + r.Host,
+		SiteURL:      siteURL(r),
 		StatsCounter: *service.GetStats(),
 	}
 }